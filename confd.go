@@ -27,8 +27,17 @@ func main() {
 		log.Fatal(err.Error())
 	}
 	templateConfig.StoreClient = storeClient
+	if flag.Arg(0) == "restore" {
+		if err := restore(flag.Args()[1:]); err != nil {
+			log.Fatal(err.Error())
+		}
+		os.Exit(0)
+	}
 	if onetime {
 		if err := template.Process(templateConfig); err != nil {
+			if err == template.ErrPendingChanges {
+				os.Exit(3)
+			}
 			os.Exit(1)
 		}
 		os.Exit(0)
@@ -46,14 +55,25 @@ func main() {
 	go processor.Process()
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	stopping := false
 	for {
 		select {
 		case err := <-errChan:
 			log.Error(err.Error())
 		case s := <-signalChan:
+			if stopping {
+				log.Info(fmt.Sprintf("Captured %v again. Exiting without waiting for pending reloads", s))
+				os.Exit(1)
+			}
 			log.Info(fmt.Sprintf("Captured %v. Exiting...", s))
-			close(doneChan)
+			// Let the processor run its pending reloads before exiting.
+			stopping = true
+			close(stopChan)
 		case <-doneChan:
+			// Log the errors of the reloads run while stopping.
+			for len(errChan) > 0 {
+				log.Error((<-errChan).Error())
+			}
 			os.Exit(0)
 		}
 	}
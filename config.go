@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/wuranbo/confd/backends"
@@ -31,6 +32,7 @@ var (
 	keepStageFile     bool
 	nodes             Nodes
 	noop              bool
+	noopFormat        string
 	onetime           bool
 	prefix            string
 	printVersion      bool
@@ -45,21 +47,26 @@ var (
 
 // A Config structure is used to configure confd.
 type Config struct {
-	Backend      string   `toml:"backend"`
-	BackendNodes []string `toml:"nodes"`
-	ClientCaKeys string   `toml:"client_cakeys"`
-	ClientCert   string   `toml:"client_cert"`
-	ClientKey    string   `toml:"client_key"`
-	ConfDir      string   `toml:"confdir"`
-	Debug        bool     `toml:"debug"`
-	Interval     int      `toml:"interval"`
-	Noop         bool     `toml:"noop"`
-	Prefix       string   `toml:"prefix"`
-	Quiet        bool     `toml:"quiet"`
-	SRVDomain    string   `toml:"srv_domain"`
-	Scheme       string   `toml:"scheme"`
-	Verbose      bool     `toml:"verbose"`
-	Watch        bool     `toml:"watch"`
+	Backend        string   `toml:"backend"`
+	BackendNodes   []string `toml:"nodes"`
+	ClientCaKeys   string   `toml:"client_cakeys"`
+	ClientCert     string   `toml:"client_cert"`
+	ClientKey      string   `toml:"client_key"`
+	ConfDir        string   `toml:"confdir"`
+	Debug          bool     `toml:"debug"`
+	HostFacts      string   `toml:"host_facts"`
+	Interval       int      `toml:"interval"`
+	Keyring        string   `toml:"keyring"`
+	Noop           bool     `toml:"noop"`
+	NoopFormat     string   `toml:"noop_format"`
+	PartialDir     string   `toml:"partial_dir"`
+	Prefix         string   `toml:"prefix"`
+	Quiet          bool     `toml:"quiet"`
+	ReloadDebounce string   `toml:"reload_debounce"`
+	SRVDomain      string   `toml:"srv_domain"`
+	Scheme         string   `toml:"scheme"`
+	Verbose        bool     `toml:"verbose"`
+	Watch          bool     `toml:"watch"`
 }
 
 func init() {
@@ -74,6 +81,7 @@ func init() {
 	flag.BoolVar(&keepStageFile, "keep-stage-file", false, "keep staged files")
 	flag.Var(&nodes, "node", "list of backend nodes")
 	flag.BoolVar(&noop, "noop", false, "only show pending changes")
+	flag.StringVar(&noopFormat, "noop-format", "diff", "format of the pending changes shown in noop mode (diff or json)")
 	flag.BoolVar(&onetime, "onetime", false, "run once and exit")
 	flag.StringVar(&prefix, "prefix", "/", "key path prefix")
 	flag.BoolVar(&printVersion, "version", false, "print version and exit")
@@ -96,11 +104,14 @@ func initConfig() error {
 	}
 	// Set defaults.
 	config = Config{
-		Backend:  "etcd",
-		ConfDir:  "/etc/confd",
-		Interval: 600,
-		Prefix:   "/",
-		Scheme:   "http",
+		Backend:        "etcd",
+		ConfDir:        "/etc/confd",
+		Interval:       600,
+		NoopFormat:     "diff",
+		PartialDir:     "partials",
+		Prefix:         "/",
+		ReloadDebounce: "0s",
+		Scheme:         "http",
 	}
 	// Update config from the TOML configuration file.
 	if configFile == "" {
@@ -124,6 +135,14 @@ func initConfig() error {
 	log.SetVerbose(config.Verbose)
 	log.SetDebug(config.Debug)
 
+	if config.NoopFormat != "diff" && config.NoopFormat != "json" {
+		return errors.New("Invalid noop format " + config.NoopFormat + " - must be diff or json")
+	}
+	reloadDebounce, err := time.ParseDuration(config.ReloadDebounce)
+	if err != nil {
+		return errors.New("Invalid reload debounce " + config.ReloadDebounce + " - " + err.Error())
+	}
+
 	// Update BackendNodes from SRV records.
 	if config.Backend != "env" && config.SRVDomain != "" {
 		log.Info("SRV domain set to " + config.SRVDomain)
@@ -171,14 +190,29 @@ func initConfig() error {
 		BackendNodes: config.BackendNodes,
 		Scheme:       config.Scheme,
 	}
+	hostFacts, err := template.LoadHostFacts(config.HostFacts)
+	if err != nil {
+		return err
+	}
+	keyring, err := template.LoadKeyring(config.Keyring)
+	if err != nil {
+		return err
+	}
 	// Template configuration.
 	templateConfig = template.Config{
-		ConfDir:       config.ConfDir,
-		ConfigDir:     filepath.Join(config.ConfDir, "conf.d"),
-		KeepStageFile: keepStageFile,
-		Noop:          config.Noop,
-		Prefix:        config.Prefix,
-		TemplateDir:   filepath.Join(config.ConfDir, "templates"),
+		ConfDir:        config.ConfDir,
+		ConfigDir:      filepath.Join(config.ConfDir, "conf.d"),
+		HostFacts:      hostFacts,
+		KeepStageFile:  keepStageFile,
+		Keyring:        keyring,
+		Noop:           config.Noop,
+		NoopFormat:     config.NoopFormat,
+		Prefix:         config.Prefix,
+		ReloadDebounce: reloadDebounce,
+		TemplateDir:    filepath.Join(config.ConfDir, "templates"),
+	}
+	if config.PartialDir != "" {
+		templateConfig.PartialDir = filepath.Join(templateConfig.TemplateDir, config.PartialDir)
 	}
 	return nil
 }
@@ -224,6 +258,8 @@ func setConfigFromFlag(f *flag.Flag) {
 		config.Interval = interval
 	case "noop":
 		config.Noop = noop
+	case "noop-format":
+		config.NoopFormat = noopFormat
 	case "prefix":
 		config.Prefix = prefix
 	case "quiet":
@@ -10,20 +10,23 @@ import (
 func TestInitConfigDefaultConfig(t *testing.T) {
 	log.SetQuiet(true)
 	want := Config{
-		Backend:      "etcd",
-		BackendNodes: []string{"http://127.0.0.1:4001"},
-		ClientCaKeys: "",
-		ClientCert:   "",
-		ClientKey:    "",
-		ConfDir:      "/etc/confd",
-		Debug:        false,
-		Interval:     600,
-		Noop:         false,
-		Prefix:       "/",
-		Quiet:        false,
-		SRVDomain:    "",
-		Scheme:       "http",
-		Verbose:      false,
+		Backend:        "etcd",
+		BackendNodes:   []string{"http://127.0.0.1:4001"},
+		ClientCaKeys:   "",
+		ClientCert:     "",
+		ClientKey:      "",
+		ConfDir:        "/etc/confd",
+		Debug:          false,
+		Interval:       600,
+		Noop:           false,
+		NoopFormat:     "diff",
+		PartialDir:     "partials",
+		Prefix:         "/",
+		Quiet:          false,
+		ReloadDebounce: "0s",
+		SRVDomain:      "",
+		Scheme:         "http",
+		Verbose:        false,
 	}
 	if err := initConfig(); err != nil {
 		t.Errorf(err.Error())
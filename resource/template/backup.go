@@ -0,0 +1,243 @@
+package template
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wuranbo/confd/log"
+)
+
+// defaultBackupKeep is the number of backups kept when backup_keep is unset.
+const defaultBackupKeep = 5
+
+// backupTimeLayout is the UTC timestamp in backup names. It sorts in time
+// order.
+const backupTimeLayout = "20060102T150405.000Z"
+
+// Backup describes a saved version of a target config. Backups are named
+// after the template resource, the time they were taken and the start of the
+// SHA-256 digest of their contents, e.g. nginx.20150102T150405.000Z.5e884898da28.
+type Backup struct {
+	Path string
+	Time time.Time
+	Hash string
+}
+
+// ListBackups returns the backups of the template resource name, the
+// resource file name without its extension, newest first.
+// It returns an error if any.
+func ListBackups(config Config, name string) ([]Backup, error) {
+	t, err := findTemplateResource(config, name)
+	if err != nil {
+		return nil, err
+	}
+	return t.backups()
+}
+
+// Restore puts version of the backups of the template resource name back in
+// place of its target config, 1 being the most recent. The backup is checked
+// with the check command first and the reload command is run after, as when
+// the config is updated from the backend.
+// It returns an error if any.
+func Restore(config Config, name string, version int) error {
+	t, err := findTemplateResource(config, name)
+	if err != nil {
+		return err
+	}
+	backups, err := t.backups()
+	if err != nil {
+		return err
+	}
+	if version < 1 || version > len(backups) {
+		return fmt.Errorf("Cannot restore %s - version %d not found, %d backups available", name, version, len(backups))
+	}
+	return t.restore(backups[version-1])
+}
+
+// findTemplateResource returns the template resource name from the config
+// directory.
+func findTemplateResource(config Config, name string) (*TemplateResource, error) {
+	ts, err := getTemplateResources(config)
+	for _, t := range ts {
+		if t.name == name {
+			return t, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, errors.New("Template resource not found: " + name)
+}
+
+// restore stages b and updates the target config with it.
+func (t *TemplateResource) restore(b Backup) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.noop {
+		log.Warning("Noop mode enabled. " + t.Dest + " will not be restored from " + b.Path)
+		return nil
+	}
+	if err := t.setFileMode(); err != nil {
+		return t.failed(err)
+	}
+	if err := t.setOwnership(); err != nil {
+		return t.failed(err)
+	}
+	target, err := t.targetPath()
+	if err != nil {
+		return t.failed(err)
+	}
+	if t.CreateDirs {
+		if err := createDirs(filepath.Dir(target), t.dirMode, t.dirUid, t.dirGid); err != nil {
+			return t.failed(err)
+		}
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+	if err := copyFile(b.Path, temp); err != nil {
+		return err
+	}
+	// Give the restored config the mode, owner and group a rendered one would
+	// get, rather than those of the backup.
+	os.Chmod(temp.Name(), t.FileMode)
+	os.Chown(temp.Name(), t.Uid, t.Gid)
+	t.StageFile = temp
+	log.Info("Restoring " + t.Dest + " from " + b.Path)
+	if err := t.update(temp.Name()); err != nil {
+		return t.failed(err)
+	}
+	log.Info("Target config " + t.Dest + " has been restored")
+	return nil
+}
+
+// backups returns the backups of the template resource, newest first.
+func (t *TemplateResource) backups() ([]Backup, error) {
+	if t.BackupDir == "" {
+		return nil, errors.New("Backups are not enabled for " + t.name)
+	}
+	files, err := ioutil.ReadDir(t.BackupDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]Backup, 0)
+	for _, f := range files {
+		b, ok := parseBackupName(t.name, f.Name())
+		if !ok || f.IsDir() {
+			continue
+		}
+		b.Path = filepath.Join(t.BackupDir, f.Name())
+		backups = append(backups, b)
+	}
+	sort.Sort(sort.Reverse(byBackupTime(backups)))
+	return backups, nil
+}
+
+// saveBackup copies the target config, if it exists, to the backup directory
+// unless the most recent backup has the same contents, then removes the
+// backups beyond the number to keep.
+func (t *TemplateResource) saveBackup() error {
+	src, err := os.Open(t.Dest)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, src); err != nil {
+		return err
+	}
+	hash := fmt.Sprintf("%x", h.Sum(nil))[:12]
+	backups, err := t.backups()
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 || backups[0].Hash != hash {
+		if err := os.MkdirAll(t.BackupDir, 0700); err != nil {
+			return err
+		}
+		name := fmt.Sprintf("%s.%s.%s", t.name, time.Now().UTC().Format(backupTimeLayout), hash)
+		dest, err := os.OpenFile(filepath.Join(t.BackupDir, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer dest.Close()
+		if err := copyFile(t.Dest, dest); err != nil {
+			os.Remove(dest.Name())
+			return err
+		}
+		log.Debug("Saved backup " + dest.Name())
+		backups = append([]Backup{Backup{Path: dest.Name()}}, backups...)
+	}
+	if len(backups) <= t.BackupKeep {
+		return nil
+	}
+	for _, b := range backups[t.BackupKeep:] {
+		log.Debug("Removing old backup " + b.Path)
+		if err := os.Remove(b.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseBackupName returns the backup named file of the template resource
+// name, and whether file is one.
+func parseBackupName(name, file string) (Backup, bool) {
+	if !strings.HasPrefix(file, name+".") {
+		return Backup{}, false
+	}
+	rest := strings.TrimPrefix(file, name+".")
+	i := strings.LastIndex(rest, ".")
+	if i < 0 {
+		return Backup{}, false
+	}
+	ts, err := time.Parse(backupTimeLayout, rest[:i])
+	if err != nil {
+		return Backup{}, false
+	}
+	return Backup{Time: ts, Hash: rest[i+1:]}, true
+}
+
+// copyFile copies the contents, mode, owner and group of the file src to
+// dest.
+func copyFile(src string, dest *os.File) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stats, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dest, f); err != nil {
+		return err
+	}
+	os.Chmod(dest.Name(), stats.Mode())
+	os.Chown(dest.Name(), int(stats.Sys().(*syscall.Stat_t).Uid), int(stats.Sys().(*syscall.Stat_t).Gid))
+	return nil
+}
+
+type byBackupTime []Backup
+
+func (b byBackupTime) Len() int           { return len(b) }
+func (b byBackupTime) Less(i, j int) bool { return b[i].Time.Before(b[j].Time) }
+func (b byBackupTime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,132 @@
+package template
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wuranbo/confd/backends/env"
+	"github.com/wuranbo/confd/log"
+)
+
+func TestParseBackupName(t *testing.T) {
+	b, ok := parseBackupName("nginx", "nginx.20150102T150405.123Z.5e884898da28")
+	if !ok {
+		t.Fatal("Expected a backup name")
+	}
+	want := time.Date(2015, 1, 2, 15, 4, 5, 123000000, time.UTC)
+	if !b.Time.Equal(want) || b.Hash != "5e884898da28" {
+		t.Errorf("Expected %s and 5e884898da28, got %s and %s", want, b.Time, b.Hash)
+	}
+	for _, file := range []string{"nginx.conf", "nginx.conf.20150102T150405.123Z.5e884898da28", "app.20150102T150405.123Z.5e884898da28"} {
+		if _, ok := parseBackupName("nginx", file); ok {
+			t.Errorf("Expected %s not to be a backup of nginx", file)
+		}
+	}
+}
+
+var backupResourceConfig = `
+[template]
+src = "app.tmpl"
+dest = "%s"
+keys = [
+  "/version",
+]
+backup_dir = "%s"
+backup_keep = 2
+mode = "0640"
+check_cmd = "grep -q version {{.src}}"
+reload_cmd = "touch %s"
+`
+
+func TestBackupAndRestore(t *testing.T) {
+	log.SetQuiet(true)
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+	if err := ioutil.WriteFile(filepath.Join(tempConfDir, "templates", "app.tmpl"), []byte(`version {{getv "/version"}}`), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	destFile := filepath.Join(tempConfDir, "app.conf")
+	backupDir := filepath.Join(tempConfDir, "backups")
+	reloaded := filepath.Join(tempConfDir, "reloaded")
+	resourceConfig := fmt.Sprintf(backupResourceConfig, destFile, backupDir, reloaded)
+	if err := ioutil.WriteFile(filepath.Join(tempConfDir, "conf.d", "app.toml"), []byte(resourceConfig), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	storeClient, err := env.NewEnvClient()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	c := Config{
+		ConfDir:     tempConfDir,
+		ConfigDir:   filepath.Join(tempConfDir, "conf.d"),
+		StoreClient: storeClient,
+		TemplateDir: filepath.Join(tempConfDir, "templates"),
+	}
+	defer os.Unsetenv("VERSION")
+	for _, v := range []string{"1", "2", "3", "4", "4"} {
+		os.Setenv("VERSION", v)
+		if err := Process(c); err != nil {
+			t.Fatal(err.Error())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Versions 1 to 3 were backed up when overwritten, and only the 2 most
+	// recent backups are kept.
+	backups, err := ListBackups(c, "app")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups, got %d", len(backups))
+	}
+	for i, want := range []string{"version 3", "version 2"} {
+		contents, err := ioutil.ReadFile(backups[i].Path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if string(contents) != want {
+			t.Errorf("Expected backup %d to be %s, got %s", i+1, want, string(contents))
+		}
+	}
+
+	os.Remove(reloaded)
+	// The restored config gets the configured mode, not that of the backup.
+	if err := os.Chmod(backups[1].Path, 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := Restore(c, "app", 2); err != nil {
+		t.Fatal(err.Error())
+	}
+	contents, err := ioutil.ReadFile(destFile)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(contents) != "version 2" {
+		t.Errorf("Expected dest to be restored to version 2, got %s", string(contents))
+	}
+	if !isFileExist(reloaded) {
+		t.Errorf("Expected the reload command to run after restoring")
+	}
+	fi, err := os.Stat(destFile)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if fi.Mode() != 0640 {
+		t.Errorf("Expected the restored dest to have mode 0640, got %s", fi.Mode())
+	}
+
+	if err := Restore(c, "app", 3); err == nil {
+		t.Errorf("Expected restoring a missing version to fail")
+	}
+	if err := Restore(c, "missing", 1); err == nil {
+		t.Errorf("Expected restoring a missing resource to fail")
+	}
+}
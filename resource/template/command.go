@@ -0,0 +1,183 @@
+package template
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/wuranbo/confd/log"
+)
+
+// maxErrorOutput is the number of bytes of command output, from its end,
+// included in the error of a failed command.
+const maxErrorOutput = 4096
+
+// command is a check or reload command: a shell command line run with
+// /bin/sh -c or, when args is set, a program and its arguments run directly
+// without a shell.
+type command struct {
+	line string
+	args []string
+}
+
+// parseCommand returns the command set by a TOML string or array of strings.
+func parseCommand(v interface{}) (command, error) {
+	switch cmd := v.(type) {
+	case nil:
+		return command{}, nil
+	case string:
+		return command{line: cmd}, nil
+	case []interface{}:
+		if len(cmd) == 0 {
+			return command{}, errors.New("empty command")
+		}
+		args := make([]string, len(cmd))
+		for i, arg := range cmd {
+			s, ok := arg.(string)
+			if !ok {
+				return command{}, fmt.Errorf("argument %v is not a string", arg)
+			}
+			args[i] = s
+		}
+		return command{args: args}, nil
+	}
+	return command{}, fmt.Errorf("%v is not a string or an array of strings", v)
+}
+
+// empty reports whether no command is set.
+func (c command) empty() bool {
+	return c.line == "" && len(c.args) == 0
+}
+
+func (c command) String() string {
+	if c.args != nil {
+		return fmt.Sprintf("%q", c.args)
+	}
+	return c.line
+}
+
+// render returns the command with the template syntax of its line, or of
+// each of its arguments, executed with data.
+func (c command) render(data map[string]string) (command, error) {
+	if c.args == nil {
+		line, err := renderCommandText(c.line, data)
+		return command{line: line}, err
+	}
+	args := make([]string, len(c.args))
+	for i, arg := range c.args {
+		var err error
+		if args[i], err = renderCommandText(arg, data); err != nil {
+			return command{}, err
+		}
+	}
+	return command{args: args}, nil
+}
+
+func renderCommandText(text string, data map[string]string) (string, error) {
+	var buf bytes.Buffer
+	tmpl, err := template.New("command").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// cmd returns the exec.Cmd running the command.
+func (c command) cmd() *exec.Cmd {
+	if c.args != nil {
+		return exec.Command(c.args[0], c.args[1:]...)
+	}
+	return exec.Command("/bin/sh", "-c", c.line)
+}
+
+// commandEnv returns the environment of the check and reload commands: the
+// environment of confd, or only its PATH if clear is set, with the variables
+// of env added.
+func commandEnv(env map[string]string, clear bool) []string {
+	var base []string
+	if clear {
+		base = []string{"PATH=" + os.Getenv("PATH")}
+	} else {
+		base = os.Environ()
+	}
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		base = append(base, k+"="+env[k])
+	}
+	return base
+}
+
+// runCommand runs c in its own process group with the environment env, and
+// kills the whole group if it is still running after timeout. A zero timeout
+// means no limit. The output of the command is logged, and included in the
+// returned error if the command fails.
+// The output goes to a temporary file rather than a pipe, so that a process
+// started by the command which leaves its group, like a daemon, does not
+// keep runCommand waiting once the command itself has exited.
+// It returns nil if the command returns 0.
+func runCommand(c *exec.Cmd, env []string, timeout time.Duration) error {
+	out, err := ioutil.TempFile("", "confd-command")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	c.Env = env
+	c.Stdout = out
+	c.Stderr = out
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := c.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Wait()
+	}()
+	if timeout > 0 {
+		select {
+		case err = <-done:
+		case <-time.After(timeout):
+			syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+			<-done
+			err = errors.New("timed out after " + timeout.String())
+		}
+	} else {
+		err = <-done
+	}
+	output, readErr := ioutil.ReadFile(out.Name())
+	if readErr != nil {
+		log.Error("Cannot read the command output - " + readErr.Error())
+	}
+	log.Debug(fmt.Sprintf("%q", output))
+	if err != nil {
+		return commandError(err, output)
+	}
+	return nil
+}
+
+// commandError returns err with the end of the output of the failed command.
+func commandError(err error, output []byte) error {
+	out := strings.TrimSpace(string(output))
+	if out == "" {
+		return err
+	}
+	if len(out) > maxErrorOutput {
+		out = "..." + out[len(out)-maxErrorOutput:]
+	}
+	return errors.New(err.Error() + " - " + out)
+}
@@ -0,0 +1,130 @@
+package template
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunCommandOutputInError(t *testing.T) {
+	c := exec.Command("/bin/sh", "-c", "echo checking; echo syntax error on line 3 >&2; exit 1")
+	err := runCommand(c, nil, 0)
+	if err == nil {
+		t.Fatal("Expected the command to fail")
+	}
+	if !strings.Contains(err.Error(), "exit status 1") || !strings.Contains(err.Error(), "syntax error on line 3") {
+		t.Errorf("Expected the error to include the exit status and output, got %s", err.Error())
+	}
+}
+
+func TestRunCommandTimeoutKillsProcessGroup(t *testing.T) {
+	// The background sleep keeps the output open, so the command only
+	// returns once the whole process group is killed.
+	c := exec.Command("/bin/sh", "-c", "sleep 5 & sleep 5")
+	start := time.Now()
+	err := runCommand(c, nil, 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("Expected the command to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Expected the command to be killed on time, took %s", elapsed)
+	}
+}
+
+func TestRunCommandTimeoutIgnoresDetachedProcesses(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("setsid is not installed")
+	}
+	pidFile, err := ioutil.TempFile("", "confd-pid")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	pidFile.Close()
+	defer os.Remove(pidFile.Name())
+	// The setsid sleep leaves the process group and keeps the output open,
+	// so it survives the kill and must not be waited for. It leads its own
+	// process group, which is killed once the test is done.
+	c := exec.Command("/bin/sh", "-c", "setsid sleep 5 & echo $! > "+pidFile.Name()+"; sleep 60")
+	defer func() {
+		b, err := ioutil.ReadFile(pidFile.Name())
+		if err != nil {
+			t.Error(err.Error())
+			return
+		}
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+			syscall.Kill(-pid, syscall.SIGKILL)
+		}
+	}()
+	start := time.Now()
+	err = runCommand(c, nil, 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("Expected the command to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Expected runCommand to return on time, took %s", elapsed)
+	}
+}
+
+func TestRunCommandEnv(t *testing.T) {
+	os.Setenv("CONFD_TEST_INHERITED", "yes")
+	defer os.Unsetenv("CONFD_TEST_INHERITED")
+	env := map[string]string{"SERVICE": "nginx"}
+	c := exec.Command("/bin/sh", "-c", `test "$SERVICE" = nginx && test "$CONFD_TEST_INHERITED" = yes`)
+	if err := runCommand(c, commandEnv(env, false), 0); err != nil {
+		t.Errorf("Expected the environment to be set, got %s", err.Error())
+	}
+	c = exec.Command("/bin/sh", "-c", `test "$SERVICE" = nginx && test -z "$CONFD_TEST_INHERITED"`)
+	if err := runCommand(c, commandEnv(env, true), 0); err != nil {
+		t.Errorf("Expected only the configured environment to be set, got %s", err.Error())
+	}
+}
+
+func TestCommandEnvClear(t *testing.T) {
+	expected := []string{"PATH=" + os.Getenv("PATH"), "A=1", "B=2"}
+	if actual := commandEnv(map[string]string{"B": "2", "A": "1"}, true); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected command
+	}{
+		{nil, command{}},
+		{"nginx -t -c {{.src}}", command{line: "nginx -t -c {{.src}}"}},
+		{[]interface{}{"nginx", "-t", "-c", "{{.src}}"}, command{args: []string{"nginx", "-t", "-c", "{{.src}}"}}},
+	}
+	for _, tt := range tests {
+		actual, err := parseCommand(tt.value)
+		if err != nil {
+			t.Errorf("parseCommand(%v) failed: %s", tt.value, err.Error())
+		}
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("parseCommand(%v): expected %+v, got %+v", tt.value, tt.expected, actual)
+		}
+	}
+	for _, bad := range []interface{}{[]interface{}{}, []interface{}{"nginx", int64(1)}, int64(1)} {
+		if _, err := parseCommand(bad); err == nil {
+			t.Errorf("Expected parseCommand(%v) to fail", bad)
+		}
+	}
+}
+
+func TestCommandRenderArgs(t *testing.T) {
+	c := command{args: []string{"nginx", "-t", "-c", "{{.src}}"}}
+	actual, err := c.render(map[string]string{"src": "/etc/nginx/.nginx.conf; rm -rf /"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := command{args: []string{"nginx", "-t", "-c", "/etc/nginx/.nginx.conf; rm -rf /"}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, actual)
+	}
+}
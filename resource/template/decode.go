@@ -0,0 +1,107 @@
+package template
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+// decoders maps the codec names accepted in [template.decode] to the
+// functions decoding values written with them.
+var decoders = map[string]func(string) (string, error){
+	"base64":      decodeBase64,
+	"gzip":        decodeGzip,
+	"hex":         decodeHex,
+	"json-string": decodeJSONString,
+}
+
+// DecodeRules maps key glob patterns to the codec values of matching keys
+// are decoded with.
+type DecodeRules map[string]string
+
+// Validate checks that every pattern is a valid glob and every codec is
+// known.
+func (r DecodeRules) Validate() error {
+	for pattern, codec := range r {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid decode pattern %q - %s", pattern, err.Error())
+		}
+		if _, ok := decoders[codec]; !ok {
+			return fmt.Errorf("Unknown codec %q for decode pattern %q", codec, pattern)
+		}
+	}
+	return nil
+}
+
+// Decode returns value decoded with the codec of the rule matching key, or
+// value unchanged if no rule matches. When several patterns match, the
+// longest one wins.
+func (r DecodeRules) Decode(key, value string) (string, error) {
+	patterns := make([]string, 0, len(r))
+	for pattern := range r {
+		patterns = append(patterns, pattern)
+	}
+	sort.Sort(byLengthDesc(patterns))
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, key); !ok {
+			continue
+		}
+		decoded, err := decoders[r[pattern]](value)
+		if err != nil {
+			return "", fmt.Errorf("%s: cannot decode %s value - %s", key, r[pattern], err.Error())
+		}
+		return decoded, nil
+	}
+	return value, nil
+}
+
+func decodeBase64(value string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(value)
+	return string(b), err
+}
+
+// decodeGzip decodes gzip compressed data that was base64 encoded.
+func decodeGzip(value string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	out, err := ioutil.ReadAll(r)
+	return string(out), err
+}
+
+func decodeHex(value string) (string, error) {
+	b, err := hex.DecodeString(value)
+	return string(b), err
+}
+
+// decodeJSONString decodes a JSON string literal, quotes included.
+func decodeJSONString(value string) (string, error) {
+	var s string
+	err := json.Unmarshal([]byte(value), &s)
+	return s, err
+}
+
+type byLengthDesc []string
+
+func (s byLengthDesc) Len() int { return len(s) }
+
+func (s byLengthDesc) Less(i, j int) bool {
+	if len(s[i]) != len(s[j]) {
+		return len(s[i]) > len(s[j])
+	}
+	return s[i] < s[j]
+}
+
+func (s byLengthDesc) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,85 @@
+package template
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"testing"
+)
+
+func gzipBase64(s string) string {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte(s))
+	w.Close()
+	return base64.StdEncoding.EncodeToString(b.Bytes())
+}
+
+func TestDecodeRules(t *testing.T) {
+	rules := DecodeRules{
+		"/app/*":        "base64",
+		"/app/bundle":   "gzip",
+		"/app/hex/*":    "hex",
+		"/app/json/*":   "json-string",
+		"/app/nomatch?": "base64",
+	}
+	if err := rules.Validate(); err != nil {
+		t.Fatal(err.Error())
+	}
+	tests := []struct {
+		key, value, want string
+	}{
+		{"/app/password", "c2VjcmV0", "secret"},
+		{"/app/bundle", gzipBase64("line1\nline2\n"), "line1\nline2\n"},
+		{"/app/hex/key", "6b6579", "key"},
+		{"/app/json/motd", `"hello\n\"world\""`, "hello\n\"world\""},
+		{"/other/key", "plain", "plain"},
+	}
+	for _, tt := range tests {
+		got, err := rules.Decode(tt.key, tt.value)
+		if err != nil {
+			t.Errorf("Decode(%s) failed: %s", tt.key, err.Error())
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Decode(%s) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeRulesInvalidValue(t *testing.T) {
+	rules := DecodeRules{"/app/*": "base64"}
+	if _, err := rules.Decode("/app/password", "not base64!"); err == nil {
+		t.Errorf("Expected Decode to fail on an invalid base64 value")
+	}
+}
+
+func TestDecodeRulesValidate(t *testing.T) {
+	if err := (DecodeRules{"/app/*": "rot13"}).Validate(); err == nil {
+		t.Errorf("Expected Validate to fail on an unknown codec")
+	}
+	if err := (DecodeRules{"/app/[": "base64"}).Validate(); err == nil {
+		t.Errorf("Expected Validate to fail on an invalid pattern")
+	}
+}
+
+func TestDecodeLayers(t *testing.T) {
+	k := &Keyring{}
+	if err := k.AddKey(testKey); err != nil {
+		t.Fatal(err.Error())
+	}
+	layers := map[string]Layer{
+		"/app/password": {"/app/password", testCiphertext, "/"},
+		"/app/cert":     {"/app/cert", "c2VjcmV0", "/"},
+	}
+	values, err := decodeLayers(layers, k, DecodeRules{"/app/cert": "base64"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if values["/app/password"] != "s3cr3t" || values["/app/cert"] != "secret" {
+		t.Errorf("Expected the values to be decrypted and decoded, got %v", values)
+	}
+	if _, err := decodeLayers(layers, &Keyring{}, nil); err == nil {
+		t.Error("Expected decodeLayers to fail when a value cannot be decrypted")
+	}
+}
@@ -0,0 +1,130 @@
+package template
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+var diffTests = []struct {
+	desc     string
+	from, to string
+	expected string
+}{
+	{"equal", "a\nb\n", "a\nb\n", ""},
+	{"empty", "", "", ""},
+	{"new file", "", "a\nb\n", `--- old
++++ new
+@@ -0,0 +1,2 @@
++a
++b
+`},
+	{"changed line", "1\n2\n3\n4\n5\n6\n7\n8\n9\n", "1\n2\n3\n4\nfive\n6\n7\n8\n9\n", `--- old
++++ new
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+`},
+	{"separate hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n", "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n", `--- old
++++ new
+@@ -1,4 +1,4 @@
+-1
++one
+ 2
+ 3
+ 4
+@@ -9,4 +9,3 @@
+ 9
+ 10
+ 11
+-12
+`},
+	{"missing newline", "a\nb", "a\nb\n", `--- old
++++ new
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++b
+`},
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, tt := range diffTests {
+		if actual := unifiedDiff("old", "new", tt.from, tt.to); actual != tt.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.desc, tt.expected, actual)
+		}
+	}
+}
+
+// numberedLines returns n lines made of prefix and the line number.
+func numberedLines(prefix string, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%s%d\n", prefix, i)
+	}
+	return lines
+}
+
+func TestDiffLinesLargeFiles(t *testing.T) {
+	tests := []struct {
+		desc string
+		a, b []string
+	}{
+		{"created", nil, numberedLines("line ", 20000)},
+		{"rewritten", numberedLines("old ", 20000), numberedLines("new ", 20000)},
+	}
+	for _, tt := range tests {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		ops := diffLines(tt.a, tt.b)
+		runtime.ReadMemStats(&after)
+		if len(ops) != len(tt.a)+len(tt.b) {
+			t.Errorf("%s: expected %d edits, got %d", tt.desc, len(tt.a)+len(tt.b), len(ops))
+		}
+		for i, op := range ops {
+			if (i < len(tt.a) && op.kind != '-') || (i >= len(tt.a) && op.kind != '+') {
+				t.Errorf("%s: expected the removed lines followed by the added lines, got %q at %d", tt.desc, op.kind, i)
+				break
+			}
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+			t.Errorf("%s: expected the diff to allocate less than 64 MB, allocated %d bytes", tt.desc, allocated)
+		}
+	}
+}
+
+func TestDiffLinesReproducesInputs(t *testing.T) {
+	a := numberedLines("line ", 2000)
+	b := append([]string(nil), a...)
+	for i := 0; i < len(b); i += 20 {
+		b[i] = "changed\n"
+	}
+	b = append(b[:500], b[900:]...)
+	var gotA, gotB []string
+	edits := 0
+	for _, op := range diffLines(a, b) {
+		if op.kind != '+' {
+			gotA = append(gotA, op.line)
+		}
+		if op.kind != '-' {
+			gotB = append(gotB, op.line)
+		}
+		if op.kind != ' ' {
+			edits++
+		}
+	}
+	if !reflect.DeepEqual(gotA, a) || !reflect.DeepEqual(gotB, b) {
+		t.Fatal("Expected the edit script to turn a into b")
+	}
+	if edits >= len(a)+len(b) {
+		t.Errorf("Expected a shorter edit script than replacing every line, got %d edits", edits)
+	}
+}
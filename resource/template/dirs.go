@@ -0,0 +1,83 @@
+package template
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/wuranbo/confd/log"
+)
+
+// defaultDirMode is the permission mode of the directories created by
+// create_dirs when dir_mode is not set.
+const defaultDirMode = 0755
+
+// parseDirSettings parses the dir_mode, dir_owner and dir_group settings of
+// the directories created by create_dirs.
+func (t *TemplateResource) parseDirSettings() error {
+	t.dirMode = defaultDirMode
+	t.dirUid, t.dirGid = -1, -1
+	var err error
+	if t.DirMode != "" {
+		mode, err := strconv.ParseUint(t.DirMode, 0, 32)
+		if err != nil {
+			return errors.New("dir_mode: " + err.Error())
+		}
+		t.dirMode = os.FileMode(mode)
+	}
+	if t.DirOwner != "" {
+		if t.dirUid, err = lookupUid(t.DirOwner); err != nil {
+			return errors.New("dir_owner: " + err.Error())
+		}
+	}
+	if t.DirGroup != "" {
+		if t.dirGid, err = lookupGid(t.DirGroup); err != nil {
+			return errors.New("dir_group: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// stageDir returns the directory the stage file of target is created in:
+// the directory of target, created first if it is missing and create_dirs is
+// set. In noop mode missing directories are not created, and the default
+// temporary directory is used instead.
+func (t *TemplateResource) stageDir(target string) (string, error) {
+	dir := filepath.Dir(target)
+	if !t.CreateDirs || isFileExist(dir) {
+		return dir, nil
+	}
+	if t.noop {
+		log.Warning("Noop mode enabled. " + dir + " will not be created")
+		return "", nil
+	}
+	if err := createDirs(dir, t.dirMode, t.dirUid, t.dirGid); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// createDirs creates dir and its missing parents, each with the given mode,
+// uid and gid. Existing directories are left unchanged.
+func createDirs(dir string, mode os.FileMode, uid, gid int) error {
+	var missing []string
+	for ; !isFileExist(dir); dir = filepath.Dir(dir) {
+		missing = append(missing, dir)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		dir := missing[i]
+		log.Info("Creating directory " + dir)
+		if err := os.Mkdir(dir, mode); err != nil {
+			return err
+		}
+		// Mkdir applies the umask, so set the mode again.
+		if err := os.Chmod(dir, mode); err != nil {
+			return err
+		}
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
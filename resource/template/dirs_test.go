@@ -0,0 +1,96 @@
+package template
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wuranbo/confd/log"
+)
+
+func TestParseDirSettings(t *testing.T) {
+	tr := &TemplateResource{}
+	if err := tr.parseDirSettings(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if tr.dirMode != defaultDirMode || tr.dirUid != -1 || tr.dirGid != -1 {
+		t.Errorf("Expected mode %o, uid -1 and gid -1, got %o, %d and %d", defaultDirMode, tr.dirMode, tr.dirUid, tr.dirGid)
+	}
+	tr = &TemplateResource{DirMode: "0750", DirOwner: "root", DirGroup: "0"}
+	if err := tr.parseDirSettings(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if tr.dirMode != 0750 || tr.dirUid != 0 || tr.dirGid != 0 {
+		t.Errorf("Expected mode 750, uid 0 and gid 0, got %o, %d and %d", tr.dirMode, tr.dirUid, tr.dirGid)
+	}
+	tr = &TemplateResource{DirMode: "rwx"}
+	if err := tr.parseDirSettings(); err == nil {
+		t.Error("Expected an invalid dir_mode to fail")
+	}
+}
+
+var createDirsResourceConfig = `
+[template]
+src = "app.tmpl"
+dest = "%s"
+keys = [
+  "/",
+]
+create_dirs = true
+dir_mode = "0750"
+`
+
+// processCreateDirs renders app.tmpl to a dest two directories below a new
+// temporary directory, and returns that directory and the error of Process.
+func processCreateDirs(t *testing.T, noop bool) (string, error) {
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	destFile := filepath.Join(tempConfDir, "etc", "app", "app.conf")
+	resourceConfig := fmt.Sprintf(createDirsResourceConfig, destFile)
+	return tempConfDir, processTestResources(t, tempConfDir, "new", func(c *Config) {
+		c.Noop = noop
+	}, resourceConfig)
+}
+
+func TestProcessCreatesDirs(t *testing.T) {
+	log.SetQuiet(true)
+	tempConfDir, err := processCreateDirs(t, false)
+	defer os.RemoveAll(tempConfDir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, dir := range []string{"etc", filepath.Join("etc", "app")} {
+		fi, err := os.Stat(filepath.Join(tempConfDir, dir))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if fi.Mode().Perm() != 0750 {
+			t.Errorf("Expected %s to have mode 0750, got %s", dir, fi.Mode().Perm())
+		}
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(tempConfDir, "etc", "app", "app.conf"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(contents) != "new" {
+		t.Errorf("Expected %q, got %q", "new", string(contents))
+	}
+}
+
+func TestProcessNoopDoesNotCreateDirs(t *testing.T) {
+	log.SetQuiet(true)
+	noopOutput = ioutil.Discard
+	defer func() { noopOutput = os.Stdout }()
+	tempConfDir, err := processCreateDirs(t, true)
+	defer os.RemoveAll(tempConfDir)
+	if err != ErrPendingChanges {
+		t.Fatalf("Expected ErrPendingChanges, got %v", err)
+	}
+	if isFileExist(filepath.Join(tempConfDir, "etc")) {
+		t.Error("Expected no directory to be created in noop mode")
+	}
+}
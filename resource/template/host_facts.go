@@ -0,0 +1,241 @@
+package template
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/wuranbo/confd/log"
+)
+
+// HostFacts describes the machine templates are rendered on.
+type HostFacts struct {
+	Hostname   string              `toml:"hostname"`
+	FQDN       string              `toml:"fqdn"`
+	PrimaryIP  string              `toml:"primary_ip"`
+	Interfaces map[string][]string `toml:"interfaces"` // interface name to addresses
+	CPUCount   int                 `toml:"cpu_count"`
+	MemTotal   uint64              `toml:"mem_total"` // bytes
+}
+
+var (
+	localFacts     HostFacts
+	localFactsOnce sync.Once
+)
+
+// LocalHostFacts returns the facts of the local machine. They are gathered
+// once, on the first call.
+func LocalHostFacts() *HostFacts {
+	localFactsOnce.Do(func() {
+		localFacts = gatherHostFacts()
+	})
+	facts := localFacts
+	facts.Interfaces = make(map[string][]string)
+	for name, addrs := range localFacts.Interfaces {
+		facts.Interfaces[name] = addrs
+	}
+	return &facts
+}
+
+// LoadHostFacts returns the facts of the local machine, with any fact set in
+// the TOML file at path taking precedence. An empty path loads no overrides.
+// It returns an error if the file cannot be decoded.
+func LoadHostFacts(path string) (*HostFacts, error) {
+	facts := LocalHostFacts()
+	if path == "" {
+		return facts, nil
+	}
+	log.Debug("Loading host facts from " + path)
+	var overrides HostFacts
+	if _, err := toml.DecodeFile(path, &overrides); err != nil {
+		return nil, errors.New("Cannot load host facts " + path + " - " + err.Error())
+	}
+	if overrides.Hostname != "" {
+		facts.Hostname = overrides.Hostname
+	}
+	if overrides.FQDN != "" {
+		facts.FQDN = overrides.FQDN
+	}
+	if overrides.PrimaryIP != "" {
+		facts.PrimaryIP = overrides.PrimaryIP
+	}
+	for name, addrs := range overrides.Interfaces {
+		facts.Interfaces[name] = addrs
+	}
+	if overrides.CPUCount != 0 {
+		facts.CPUCount = overrides.CPUCount
+	}
+	if overrides.MemTotal != 0 {
+		facts.MemTotal = overrides.MemTotal
+	}
+	return facts, nil
+}
+
+// FuncMap returns the template functions exposing the facts.
+func (f *HostFacts) FuncMap() map[string]interface{} {
+	return map[string]interface{}{
+		"hostname":       func() string { return f.Hostname },
+		"fqdn":           func() string { return f.FQDN },
+		"primaryIP":      func() string { return f.PrimaryIP },
+		"interfaceAddrs": f.interfaceAddrs,
+		"cpuCount":       func() int { return f.CPUCount },
+		"memTotal":       func() uint64 { return f.MemTotal },
+	}
+}
+
+// interfaceAddrs returns the addresses of the named network interface.
+func (f *HostFacts) interfaceAddrs(name string) ([]string, error) {
+	addrs, ok := f.Interfaces[name]
+	if !ok {
+		return nil, errors.New("Unknown network interface " + name)
+	}
+	return addrs, nil
+}
+
+// gatherHostFacts reads the facts of the local machine. Facts that cannot be
+// determined are left empty.
+func gatherHostFacts() HostFacts {
+	var facts HostFacts
+	facts.Hostname, _ = os.Hostname()
+	facts.FQDN = lookupFQDN(facts.Hostname)
+	facts.Interfaces = interfaceAddrs()
+	facts.PrimaryIP = primaryIP(facts.Interfaces)
+	facts.CPUCount = runtime.NumCPU()
+	if f, err := os.Open("/proc/cpuinfo"); err == nil {
+		if n := parseCPUCount(f); n > 0 {
+			facts.CPUCount = n
+		}
+		f.Close()
+	}
+	if f, err := os.Open("/proc/meminfo"); err == nil {
+		facts.MemTotal = parseMemTotal(f)
+		f.Close()
+	}
+	return facts
+}
+
+// lookupFQDN returns the canonical DNS name of hostname, or hostname itself if
+// it does not resolve.
+func lookupFQDN(hostname string) string {
+	cname, err := net.LookupCNAME(hostname)
+	if err != nil || cname == "" {
+		return hostname
+	}
+	return strings.TrimSuffix(cname, ".")
+}
+
+// interfaceAddrs returns the IP addresses of every local network interface,
+// keyed by interface name.
+func interfaceAddrs() map[string][]string {
+	m := make(map[string][]string)
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return m
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		ips := make([]string, 0)
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				ips = append(ips, ipnet.IP.String())
+			}
+		}
+		m[iface.Name] = ips
+	}
+	return m
+}
+
+// primaryIP returns the first IPv4 address of the interface holding the
+// default route, falling back to the first non-loopback IPv4 address.
+func primaryIP(ifaces map[string][]string) string {
+	if f, err := os.Open("/proc/net/route"); err == nil {
+		name := parseDefaultRouteInterface(f)
+		f.Close()
+		if ip := firstIPv4(ifaces[name]); ip != "" {
+			return ip
+		}
+	}
+	return firstNonLoopbackIPv4(ifaces)
+}
+
+// firstNonLoopbackIPv4 returns the first IPv4 address of the first interface,
+// by name, whose first IPv4 address is not a loopback address. Going through
+// the interfaces in a fixed order keeps the result the same from one run to
+// the next.
+func firstNonLoopbackIPv4(ifaces map[string][]string) string {
+	names := make([]string, 0, len(ifaces))
+	for name := range ifaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ip := firstIPv4(ifaces[name])
+		if ip != "" && !net.ParseIP(ip).IsLoopback() {
+			return ip
+		}
+	}
+	return ""
+}
+
+func firstIPv4(addrs []string) string {
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+			return addr
+		}
+	}
+	return ""
+}
+
+// parseDefaultRouteInterface returns the interface of the default route in
+// the /proc/net/route format.
+func parseDefaultRouteInterface(r io.Reader) string {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && fields[1] == "00000000" {
+			return fields[0]
+		}
+	}
+	return ""
+}
+
+// parseCPUCount counts the processors listed in the /proc/cpuinfo format.
+func parseCPUCount(r io.Reader) int {
+	n := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "processor") {
+			n++
+		}
+	}
+	return n
+}
+
+// parseMemTotal returns the total memory in bytes from the /proc/meminfo
+// format, or 0 if it is not listed.
+func parseMemTotal(r io.Reader) uint64 {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0
+		}
+		return kb * 1024
+	}
+	return 0
+}
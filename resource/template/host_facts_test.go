@@ -0,0 +1,92 @@
+package template
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wuranbo/confd/log"
+)
+
+var hostFactsOverrides = `
+hostname = "web1"
+fqdn = "web1.example.com"
+cpu_count = 4
+
+[interfaces]
+eth0 = ["10.0.0.5", "fe80::1"]
+`
+
+func TestLoadHostFactsOverrides(t *testing.T) {
+	log.SetQuiet(true)
+	f, err := ioutil.TempFile("", "facts")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(hostFactsOverrides); err != nil {
+		t.Fatal(err.Error())
+	}
+	f.Close()
+	local := LocalHostFacts()
+	facts, err := LoadHostFacts(f.Name())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if facts.Hostname != "web1" || facts.FQDN != "web1.example.com" || facts.CPUCount != 4 {
+		t.Errorf("LoadHostFacts() did not apply overrides, got %+v", facts)
+	}
+	if facts.MemTotal != local.MemTotal || facts.PrimaryIP != local.PrimaryIP {
+		t.Errorf("LoadHostFacts() changed facts that were not overridden, got %+v", facts)
+	}
+	want := []string{"10.0.0.5", "fe80::1"}
+	if !reflect.DeepEqual(facts.Interfaces["eth0"], want) {
+		t.Errorf("Interfaces[eth0] = %v, want %v", facts.Interfaces["eth0"], want)
+	}
+	if !reflect.DeepEqual(LocalHostFacts(), local) {
+		t.Errorf("LoadHostFacts() modified the local host facts")
+	}
+}
+
+func TestLoadHostFactsMissingFile(t *testing.T) {
+	if _, err := LoadHostFacts("/nonexistent/facts.toml"); err == nil {
+		t.Errorf("Expected LoadHostFacts to fail for a missing file")
+	}
+}
+
+func TestParseProcFiles(t *testing.T) {
+	meminfo := "MemTotal:       16318536 kB\nMemFree:         1234567 kB\n"
+	if got, want := parseMemTotal(strings.NewReader(meminfo)), uint64(16318536*1024); got != want {
+		t.Errorf("parseMemTotal() = %d, want %d", got, want)
+	}
+	cpuinfo := "processor\t: 0\nmodel name\t: x\n\nprocessor\t: 1\nmodel name\t: x\n"
+	if got := parseCPUCount(strings.NewReader(cpuinfo)); got != 2 {
+		t.Errorf("parseCPUCount() = %d, want 2", got)
+	}
+	route := "Iface\tDestination\tGateway\n" +
+		"docker0\t000011AC\t00000000\n" +
+		"eth0\t00000000\t0100000A\n"
+	if got := parseDefaultRouteInterface(strings.NewReader(route)); got != "eth0" {
+		t.Errorf("parseDefaultRouteInterface() = %q, want %q", got, "eth0")
+	}
+}
+
+func TestFirstNonLoopbackIPv4(t *testing.T) {
+	ifaces := map[string][]string{
+		"lo":    {"127.0.0.1", "::1"},
+		"wlan0": {"192.168.1.20"},
+		"eth1":  {"fe80::1", "10.0.1.5"},
+		"eth0":  {"fe80::2"},
+	}
+	// Map iteration order varies, so run it several times.
+	for i := 0; i < 20; i++ {
+		if ip := firstNonLoopbackIPv4(ifaces); ip != "10.0.1.5" {
+			t.Fatalf("Expected 10.0.1.5, got %s", ip)
+		}
+	}
+	if ip := firstNonLoopbackIPv4(map[string][]string{"lo": {"127.0.0.1"}}); ip != "" {
+		t.Errorf("Expected no address, got %s", ip)
+	}
+}
@@ -3,10 +3,6 @@ package inmem
 import (
 	"errors"
 	"fmt"
-	"path"
-	"path/filepath"
-	"strings"
-	"text/template"
 
 	"github.com/BurntSushi/toml"
 	"github.com/kelseyhightower/memkv"
@@ -15,7 +11,10 @@ import (
 )
 
 type InmemConfig struct {
-	Prefix      string // all template and toml has same  prefix
+	HostFacts   *confdtmpl.HostFacts // facts of the rendering host, local facts if nil
+	Keyring     *confdtmpl.Keyring   // keys of encrypted values, none if nil
+	Partials    map[string]string    // shared partials, name to template text
+	Prefix      string               // all template and toml has same  prefix
 	StoreClient backends.StoreClient
 }
 
@@ -24,26 +23,43 @@ type TomlContainer struct {
 }
 
 type TomlTemplateSection struct {
-	Src  string
-	Dest string
-	Keys []string
-	Mode string
+	Src            string
+	Dest           string
+	Decode         confdtmpl.DecodeRules
+	FixedTime      string `toml:"fixed_time"`
+	Keys           []string
+	LeftDelimiter  string `toml:"left_delimiter"`
+	Mode           string
+	Prefixes       []string
+	RightDelimiter string `toml:"right_delimiter"`
+	Strict         bool
+	Vars           map[string]interface{}
 }
 
 // InmemTemplateResource is the representation of a parsed template resource.
 type InmemTemplateResource struct {
-	Origin      string
-	Data        TextResource
-	Stage       TextResource      // tmp save position, should be assigned to Dest
-	Dest        InmemTemplateDest // result in memeory
-	Src         InmemTemplateSrc  // template file in memory
-	Keys        []string
-	Prefix      string
-	funcMap     map[string]interface{}
-	lastIndex   uint64
-	prefix      string
-	store       memkv.Store
-	storeClient backends.StoreClient
+	Origin         string
+	Data           TextResource
+	Stage          TextResource      // tmp save position, should be assigned to Dest
+	Dest           InmemTemplateDest // result in memeory
+	Src            InmemTemplateSrc  // template file in memory
+	Keys           []string
+	Prefix         string
+	Prefixes       []string
+	Decode         confdtmpl.DecodeRules
+	FixedTime      string
+	LeftDelimiter  string
+	RightDelimiter string
+	Strict         bool
+	Vars           map[string]interface{}
+	funcMap        map[string]interface{}
+	hostFacts      *confdtmpl.HostFacts
+	keyring        *confdtmpl.Keyring
+	layers         memkv.Store
+	partials       map[string]string
+	prefixes       []string
+	store          memkv.Store
+	storeClient    backends.StoreClient
 }
 
 func (tr *InmemTemplateResource) Name() string {
@@ -96,33 +112,77 @@ func NewInmemTemplateResource(
 	tmpldata := make([]byte, len(tmpltext))
 	copy(tmpldata[:], tmpltext)
 	tr := InmemTemplateResource{
-		Keys: tc.TomlTemplateSection.Keys,
-		Data: TextResource{data},
-		Dest: InmemTemplateDest{Origin: tc.TomlTemplateSection.Dest},
-		Src:  InmemTemplateSrc{Origin: tc.TomlTemplateSection.Src, Data: TextResource{tmpldata}},
+		Keys:           tc.TomlTemplateSection.Keys,
+		Data:           TextResource{data},
+		Dest:           InmemTemplateDest{Origin: tc.TomlTemplateSection.Dest},
+		Src:            InmemTemplateSrc{Origin: tc.TomlTemplateSection.Src, Data: TextResource{tmpldata}},
+		FixedTime:      tc.TomlTemplateSection.FixedTime,
+		Prefixes:       tc.TomlTemplateSection.Prefixes,
+		Decode:         tc.TomlTemplateSection.Decode,
+		LeftDelimiter:  tc.TomlTemplateSection.LeftDelimiter,
+		RightDelimiter: tc.TomlTemplateSection.RightDelimiter,
+		Strict:         tc.TomlTemplateSection.Strict,
 	}
 
 	tr.storeClient = config.StoreClient
+	tr.partials = config.Partials
+	tr.keyring = config.Keyring
+	tr.hostFacts = config.HostFacts
+	if tr.hostFacts == nil {
+		tr.hostFacts = confdtmpl.LocalHostFacts()
+	}
 	tr.funcMap = confdtmpl.NewFuncMap()
+	confdtmpl.AddFuncs(tr.funcMap, tr.hostFacts.FuncMap())
+	confdtmpl.AddFuncs(tr.funcMap, tr.keyring.FuncMap())
 	tr.store = memkv.New()
-	confdtmpl.AddFuncs(tr.funcMap, tr.store.FuncMap)
-	tr.prefix = filepath.Join("/", config.Prefix, tr.Prefix)
+	tr.layers = memkv.New()
+	confdtmpl.AddFuncs(tr.funcMap, confdtmpl.NewStoreFuncMap(&tr.store, &tr.layers, tr.Strict))
+	if tr.FixedTime != "" {
+		timeFuncs, err := confdtmpl.FixedTimeFuncMap(tr.FixedTime)
+		if err != nil {
+			return nil, err
+		}
+		confdtmpl.AddFuncs(tr.funcMap, timeFuncs)
+	}
+	if tr.Vars, err = confdtmpl.InterpolateVars(tc.TomlTemplateSection.Vars, tr.hostFacts); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource, vars error:%s", err.Error())
+	}
+	if err := tr.Decode.Validate(); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource, error:%s", err.Error())
+	}
+	if err := confdtmpl.InterpolateAll(tr.Keys, tr.hostFacts); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource, keys error:%s", err.Error())
+	}
+	if err := confdtmpl.InterpolateAll(tr.Prefixes, tr.hostFacts); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource, prefixes error:%s", err.Error())
+	}
+	if tr.Dest.Origin, err = confdtmpl.Interpolate(tr.Dest.Origin, tr.hostFacts); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource, dest error:%s", err.Error())
+	}
+	tr.prefixes = confdtmpl.ResourcePrefixes(config.Prefix, tr.Prefix, tr.Prefixes)
 	if tr.Src.Origin == "" {
 		return nil, ErrEmptySrc
 	}
 	return &tr, nil
 }
 
-// setVars sets the Vars for template resource.
+// setVars sets the Vars for template resource. When the resource has several
+// prefixes, the value of a key is taken from the first prefix defining it.
+// Encrypted values are decrypted when a keyring is configured.
 func (t *InmemTemplateResource) setVars(extrakvs map[string]string) error {
-	var err error
-	result, err := t.storeClient.GetValues(appendPrefix(t.prefix, t.Keys))
+	layers, err := confdtmpl.LoadLayers(t.storeClient, t.prefixes, t.Keys)
+	if err != nil {
+		return err
+	}
+	values, err := confdtmpl.DecodeLayers(layers, t.keyring, t.Decode)
 	if err != nil {
 		return err
 	}
 	t.store.Purge()
-	for k, v := range result {
-		t.store.Set(filepath.Join("/", strings.TrimPrefix(k, t.prefix)), v)
+	t.layers.Purge()
+	for k, l := range layers {
+		t.store.Set(k, values[k])
+		t.layers.Set(k, l.Prefix)
 	}
 	for k, v := range extrakvs {
 		// presume empty string of a extrakvs is meaningless,
@@ -131,6 +191,7 @@ func (t *InmemTemplateResource) setVars(extrakvs map[string]string) error {
 			t.store.Del(k)
 		}
 		t.store.Set(k, v) // overwrite
+		t.layers.Del(k)
 	}
 	return nil
 }
@@ -141,8 +202,16 @@ func (t *InmemTemplateResource) setVars(extrakvs map[string]string) error {
 // It returns an error if any.
 func (t *InmemTemplateResource) createStage() error {
 	temp := TextResource{[]byte{}}
-	tmpl := template.Must(template.New(t.Src.Name()).Funcs(t.funcMap).Parse(t.Src.Data.String()))
-	if err := tmpl.Execute(&temp, nil); err != nil {
+	tmpl, err := confdtmpl.NewTemplate(t.Src.Name(), t.Src.Data.String(), t.funcMap, confdtmpl.TemplateOptions{
+		LeftDelim:  t.LeftDelimiter,
+		RightDelim: t.RightDelimiter,
+		Strict:     t.Strict,
+		Partials:   t.partials,
+	})
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(&temp, t.Vars); err != nil {
 		return err
 	}
 	t.Stage = temp
@@ -174,11 +243,3 @@ func (t *InmemTemplateResource) Process(extrakvs map[string]string) error {
 }
 
 var ErrEmptySrc = errors.New("empty src template")
-
-func appendPrefix(prefix string, keys []string) []string {
-	s := make([]string, len(keys))
-	for i, k := range keys {
-		s[i] = path.Join(prefix, k)
-	}
-	return s
-}
@@ -2,19 +2,32 @@ package inmem
 
 import (
 	"fmt"
+	"os"
 	"testing"
 
 	"github.com/wuranbo/confd/backends"
+	confdtmpl "github.com/wuranbo/confd/resource/template"
 )
 
 type inmemTemplateTest struct {
 	desc        string                       // description of the test (for helpful errors)
 	toml        string                       // toml file contents
 	tmpl        string                       // template file contents
+	partials    map[string]string            // partial names and contents
 	expected    string                       // expected generated file contents
 	updateStore func(*InmemTemplateResource) // function for setting values in store
 }
 
+// testHostFacts are the host facts every test template is rendered with.
+var testHostFacts = &confdtmpl.HostFacts{
+	Hostname:   "web1",
+	FQDN:       "web1.example.com",
+	PrimaryIP:  "10.0.0.5",
+	Interfaces: map[string][]string{"eth0": []string{"10.0.0.5", "fe80::1"}, "lo": []string{"127.0.0.1"}},
+	CPUCount:   4,
+	MemTotal:   8589934592,
+}
+
 // inmemTemplateTests is an array of inmemTemplateTest structs, each representing a test of
 // some aspect of template processing. When the input tmpl and toml files are
 // processed, they should produce a config file matching expected.
@@ -316,10 +329,325 @@ dir: /test/data
 			tr.store.Set("/test/data/def", "child")
 		},
 	},
+
+	inmemTemplateTest{
+		desc: "getv default test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/url",
+]
+`,
+		tmpl: `
+url = {{getv "/test/url" "http://localhost"}}
+user = {{getv "/test/user" "nobody"}}
+`,
+		expected: `
+url = http://www.abc.com
+user = nobody
+`,
+		updateStore: func(tr *InmemTemplateResource) {
+			tr.store.Set("/test/url", "http://www.abc.com")
+		},
+	},
+
+	inmemTemplateTest{
+		desc: "typed getters test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test",
+]
+`,
+		tmpl: `
+port = {{getint "/test/port"}}
+workers = {{getint "/test/workers" 4}}
+debug = {{if getbool "/test/debug"}}on{{else}}off{{end}}
+gzip = {{getbool "/test/gzip" true}}
+timeout = {{(getduration "/test/timeout").Seconds}}
+keepalive = {{getduration "/test/keepalive" "1m"}}
+`,
+		expected: `
+port = 8080
+workers = 4
+debug = on
+gzip = true
+timeout = 90
+keepalive = 1m0s
+`,
+		updateStore: func(tr *InmemTemplateResource) {
+			tr.store.Set("/test/port", "8080")
+			tr.store.Set("/test/debug", "true")
+			tr.store.Set("/test/timeout", "1m30s")
+		},
+	},
+
+	inmemTemplateTest{
+		desc: "partials test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/server",
+]
+`,
+		tmpl: `
+{{template "header.tmpl"}}
+{{range gets "/test/server/*"}}
+{{include "nginx/server.tmpl" .}}
+{{end}}
+`,
+		partials: map[string]string{
+			"header.tmpl":       `# generated by confd`,
+			"nginx/server.tmpl": `server {{.Value}}; # {{base .Key}}`,
+		},
+		expected: `
+# generated by confd
+
+server 10.0.0.1:80; # app1
+
+server 10.0.0.2:80; # app2
+
+`,
+		updateStore: func(tr *InmemTemplateResource) {
+			tr.store.Set("/test/server/app1", "10.0.0.1:80")
+			tr.store.Set("/test/server/app2", "10.0.0.2:80")
+		},
+	},
+
+	inmemTemplateTest{
+		desc: "custom delimiters test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+left_delimiter = "[["
+right_delimiter = "]]"
+keys = [
+    "/test/backend",
+]
+`,
+		tmpl: `
+backend app
+    server app1 [[getv "/test/backend"]] check
+    http-request set-header X-Tmpl {{literal}}
+`,
+		expected: `
+backend app
+    server app1 10.0.0.1:80 check
+    http-request set-header X-Tmpl {{literal}}
+`,
+		updateStore: func(tr *InmemTemplateResource) {
+			tr.store.Set("/test/backend", "10.0.0.1:80")
+		},
+	},
+
+	inmemTemplateTest{
+		desc: "regex test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test",
+]
+`,
+		tmpl: `
+{{$addr := getv "/test/addr"}}
+{{if regexMatch "^[a-z.]+:[0-9]+$" $addr}}valid{{end}}
+port: {{regexFind ":([0-9]+)$" $addr}}
+host: {{$addr | regexFind "^[^:]+"}}
+versions: {{join (regexFindAll "v([0-9]+)" (getv "/test/versions")) ","}}
+version: {{getv "/test/image" | regexReplace "^.*:v?" ""}}
+{{range regexSplit "[,; ]+" (getv "/test/hosts")}}
+host: {{.}}
+{{end}}
+`,
+		expected: `
+
+valid
+port: 8080
+host: db.example.com
+versions: 1,2,10
+version: 1.4.2
+
+host: a
+
+host: b
+
+host: c
+
+`,
+		updateStore: func(tr *InmemTemplateResource) {
+			tr.store.Set("/test/addr", "db.example.com:8080")
+			tr.store.Set("/test/versions", "api-v1 api-v2 api-v10")
+			tr.store.Set("/test/image", "nginx:v1.4.2")
+			tr.store.Set("/test/hosts", "a, b;c")
+		},
+	},
+
+	inmemTemplateTest{
+		desc: "host facts test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test",
+]
+`,
+		tmpl: `
+hostname: {{hostname}}
+fqdn: {{fqdn}}
+listen: {{primaryIP}}
+eth0: {{join (interfaceAddrs "eth0") " "}}
+workers: {{cpuCount}}
+memory: {{strdiv memTotal 1048576}}m
+`,
+		expected: `
+hostname: web1
+fqdn: web1.example.com
+listen: 10.0.0.5
+eth0: 10.0.0.5 fe80::1
+workers: 4
+memory: 8192m
+`,
+		updateStore: func(tr *InmemTemplateResource) {},
+	},
+
+	inmemTemplateTest{
+		desc: "tree test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/services",
+]
+`,
+		tmpl: `
+{{range $name, $svc := tree "/services"}}
+upstream {{$name}} {
+{{range $id, $inst := $svc}}    server {{$inst.host}}:{{$inst.port}}; # {{$id}}
+{{end}}}
+{{end}}
+{{with tree "/config"}}{{index . ""}} {{.tls.cert}}{{end}}
+{{len (tree "/nada")}}
+`,
+		expected: `
+
+upstream api {
+    server 10.0.0.3:9000; # a
+}
+
+upstream web {
+    server 10.0.0.1:80; # 1
+    server 10.0.0.2:80; # 2
+}
+
+enabled /etc/cert.pem
+0
+`,
+		updateStore: func(tr *InmemTemplateResource) {
+			tr.store.Set("/services/web/1/host", "10.0.0.1")
+			tr.store.Set("/services/web/1/port", "80")
+			tr.store.Set("/services/web/2/host", "10.0.0.2")
+			tr.store.Set("/services/web/2/port", "80")
+			tr.store.Set("/services/api/a/host", "10.0.0.3")
+			tr.store.Set("/services/api/a/port", "9000")
+			tr.store.Set("/services2/other/x/host", "10.0.0.9")
+			tr.store.Set("/config", "enabled")
+			tr.store.Set("/config/tls/cert", "/etc/cert.pem")
+		},
+	},
+
+	inmemTemplateTest{
+		desc: "time test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+fixed_time = "2015-01-23T13:34:56Z"
+keys = [
+    "/test",
+]
+`,
+		tmpl: `
+# Generated by confd {{now}}
+date: {{now "2006-01-02"}} {{now "Kitchen"}}
+datetime: {{datetime.Year}}
+{{$expires := parseTime "RFC3339" (getv "/test/expires")}}
+expires: {{$expires | toTimezone "America/New_York"}}
+renew: {{dateAdd "-720h" $expires | toTimezone "UTC"}}
+{{$left := durationBetween datetime $expires}}
+left: {{$left}} {{if lt $left.Hours 2400.0}}renew soon{{end}}
+created: {{(unixTime (getv "/test/created")).UTC.Format "2006-01-02 15:04"}}
+`,
+		expected: `
+# Generated by confd 2015-01-23T13:34:56Z
+date: 2015-01-23 1:34PM
+datetime: 2015
+
+expires: 2015-03-01 07:00:00 -0500 EST
+renew: 2015-01-30 12:00:00 +0000 UTC
+
+left: 886h25m4s renew soon
+created: 2015-01-01 00:00
+`,
+		updateStore: func(tr *InmemTemplateResource) {
+			tr.store.Set("/test/expires", "2015-03-01T12:00:00Z")
+			tr.store.Set("/test/created", "1420070400")
+		},
+	},
+
+	inmemTemplateTest{
+		desc: "vars test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test",
+]
+
+[template.vars]
+name = "web"
+port = 8080
+server_name = "{{hostname}}.{{getenv \"CONFD_TEST_DOMAIN\"}}"
+aliases = ["www.{{getenv \"CONFD_TEST_DOMAIN\"}}", "static"]
+
+[template.vars.tls]
+enabled = true
+`,
+		tmpl: `
+upstream {{.name}} {
+    server {{getv "/test/host"}}:{{.port}};
+}
+server_name {{.server_name}}{{range .aliases}} {{.}}{{end}};
+{{if .tls.enabled}}listen 443 ssl;{{end}}
+`,
+		expected: `
+upstream web {
+    server 10.0.0.1:8080;
+}
+server_name web1.example.com www.example.com static;
+listen 443 ssl;
+`,
+		updateStore: func(tr *InmemTemplateResource) {
+			tr.store.Set("/test/host", "10.0.0.1")
+		},
+	},
 }
 
 // TestInmemTemplates runs all tests in inmemTemplateTests
 func TestInmemTemplates(t *testing.T) {
+	os.Setenv("CONFD_TEST_DOMAIN", "example.com")
+	defer os.Unsetenv("CONFD_TEST_DOMAIN")
 	for _, tt := range inmemTemplateTests {
 		ExecuteTestInmemTemplate(tt, t)
 	}
@@ -359,6 +687,8 @@ func inmemTemplateResource(testdata *inmemTemplateTest) (*InmemTemplateResource,
 	}
 
 	config := InmemConfig{
+		HostFacts:   testHostFacts,
+		Partials:    testdata.partials,
 		StoreClient: client, // not used but must be set
 	}
 
@@ -0,0 +1,132 @@
+package template
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/wuranbo/confd/log"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// EncryptedPrefix marks values encrypted with a keyring key.
+const EncryptedPrefix = "enc:"
+
+const (
+	keySize   = 32
+	nonceSize = 24
+)
+
+// Keyring holds the NaCl secretbox keys encrypted values are decrypted with.
+type Keyring struct {
+	keys []*[keySize]byte
+}
+
+// LoadKeyring reads the keyring file at path, which holds one base64 encoded
+// 32 byte key per line. Blank lines and lines starting with # are ignored.
+// An empty path loads no keyring and returns nil.
+func LoadKeyring(path string) (*Keyring, error) {
+	if path == "" {
+		return nil, nil
+	}
+	log.Debug("Loading keyring from " + path)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.New("Cannot load keyring " + path + " - " + err.Error())
+	}
+	defer f.Close()
+	k, err := parseKeyring(f)
+	if err != nil {
+		return nil, errors.New("Cannot load keyring " + path + " - " + err.Error())
+	}
+	return k, nil
+}
+
+func parseKeyring(r io.Reader) (*Keyring, error) {
+	k := &Keyring{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := k.AddKey(line); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(k.keys) == 0 {
+		return nil, errors.New("no keys found")
+	}
+	return k, nil
+}
+
+// AddKey adds a base64 encoded 32 byte key to the keyring.
+func (k *Keyring) AddKey(encoded string) error {
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return errors.New("invalid key - " + err.Error())
+	}
+	if len(b) != keySize {
+		return errors.New("invalid key - keys must be 32 bytes")
+	}
+	key := new([keySize]byte)
+	copy(key[:], b)
+	k.keys = append(k.keys, key)
+	return nil
+}
+
+// Encrypt encrypts value with the first key of the keyring and returns it
+// with EncryptedPrefix.
+func (k *Keyring) Encrypt(value string) (string, error) {
+	if k == nil || len(k.keys) == 0 {
+		return "", errors.New("No keyring configured")
+	}
+	var nonce [nonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return "", err
+	}
+	sealed := secretbox.Seal(nonce[:], []byte(value), &nonce, k.keys[0])
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns value decrypted if it has EncryptedPrefix, trying each key
+// of the keyring in turn, and value unchanged otherwise. It returns an error
+// if no key can decrypt the value, so ciphertext is never rendered.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, EncryptedPrefix) {
+		return value, nil
+	}
+	if k == nil || len(k.keys) == 0 {
+		return "", errors.New("Cannot decrypt value - no keyring configured")
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, EncryptedPrefix))
+	if err != nil {
+		return "", errors.New("Cannot decrypt value - " + err.Error())
+	}
+	if len(b) < nonceSize+secretbox.Overhead {
+		return "", errors.New("Cannot decrypt value - too short")
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], b[:nonceSize])
+	for _, key := range k.keys {
+		if out, ok := secretbox.Open(nil, b[nonceSize:], &nonce, key); ok {
+			return string(out), nil
+		}
+	}
+	return "", errors.New("Cannot decrypt value - no key in the keyring matches")
+}
+
+// FuncMap returns the decrypt template function backed by the keyring, which
+// may be nil.
+func (k *Keyring) FuncMap() map[string]interface{} {
+	return map[string]interface{}{
+		"decrypt": k.Decrypt,
+	}
+}
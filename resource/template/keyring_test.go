@@ -0,0 +1,81 @@
+package template
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testKey        = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+	testOtherKey   = "Hx4dHBsaGRgXFhUUExIREA8ODQwLCgkIBwYFBAMCAQA="
+	testCiphertext = "enc:ZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXp7uMKmwNwRrBo2dFJkWnqROnGK+rsJwg==" // s3cr3t
+)
+
+func TestParseKeyring(t *testing.T) {
+	k, err := parseKeyring(strings.NewReader("# old key\n" + testOtherKey + "\n\n" + testKey + "\n"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(k.keys) != 2 {
+		t.Errorf("Expected 2 keys, got %d", len(k.keys))
+	}
+	for _, bad := range []string{"", "# no keys\n", "not base64!\n", "AAEC\n"} {
+		if _, err := parseKeyring(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected parsing keyring %q to fail", bad)
+		}
+	}
+}
+
+func TestKeyringDecrypt(t *testing.T) {
+	k, err := parseKeyring(strings.NewReader(testOtherKey + "\n" + testKey + "\n"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	got, err := k.Decrypt(testCiphertext)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if got != "s3cr3t" {
+		t.Errorf("Expected s3cr3t, got %q", got)
+	}
+	if got, err := k.Decrypt("plain"); err != nil || got != "plain" {
+		t.Errorf("Expected plain values to be returned unchanged, got %q, %v", got, err)
+	}
+	tampered := testCiphertext[:len(testCiphertext)-4] + "AAA="
+	for _, bad := range []string{tampered, "enc:AAEC", "enc:not base64!"} {
+		if _, err := k.Decrypt(bad); err == nil {
+			t.Errorf("Expected decrypting %q to fail", bad)
+		}
+	}
+}
+
+func TestKeyringDecryptWrongKey(t *testing.T) {
+	k, err := parseKeyring(strings.NewReader(testOtherKey))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := k.Decrypt(testCiphertext); err == nil {
+		t.Errorf("Expected decrypting with the wrong key to fail")
+	}
+	var none *Keyring
+	if _, err := none.Decrypt(testCiphertext); err == nil {
+		t.Errorf("Expected decrypting without a keyring to fail")
+	}
+}
+
+func TestKeyringEncrypt(t *testing.T) {
+	k, err := parseKeyring(strings.NewReader(testKey))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	enc, err := k.Encrypt("hunter2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !strings.HasPrefix(enc, EncryptedPrefix) {
+		t.Errorf("Expected %q to start with %s", enc, EncryptedPrefix)
+	}
+	if got, err := k.Decrypt(enc); err != nil || got != "hunter2" {
+		t.Errorf("Expected hunter2, got %q, %v", got, err)
+	}
+}
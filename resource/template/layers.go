@@ -0,0 +1,85 @@
+package template
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/wuranbo/confd/backends"
+)
+
+// Layer describes the value of a key and the prefix it was found under.
+type Layer struct {
+	Key    string
+	Value  string
+	Prefix string
+}
+
+func LoadLayers(client backends.StoreClient, prefixes, keys []string) (map[string]Layer, error) {
+	return loadLayers(client, prefixes, keys)
+}
+
+// loadLayers retrieves keys under each of prefixes, which are in priority
+// order, and merges the results so that the first prefix defining a key wins.
+// The returned layers are keyed by the key with its prefix removed.
+// It returns an error if any of the lookups fails.
+func loadLayers(client backends.StoreClient, prefixes, keys []string) (map[string]Layer, error) {
+	layers := make(map[string]Layer)
+	for _, prefix := range prefixes {
+		result, err := client.GetValues(appendPrefix(prefix, keys))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range result {
+			key := filepath.Join("/", strings.TrimPrefix(k, prefix))
+			if _, ok := layers[key]; ok {
+				continue
+			}
+			layers[key] = Layer{key, v, prefix}
+		}
+	}
+	return layers, nil
+}
+
+func DecodeLayers(layers map[string]Layer, keyring *Keyring, rules DecodeRules) (map[string]string, error) {
+	return decodeLayers(layers, keyring, rules)
+}
+
+// decodeLayers returns the value of each key of layers, decrypted with keyring
+// when it is set, then decoded with rules.
+// It returns an error if a value cannot be decrypted or decoded.
+func decodeLayers(layers map[string]Layer, keyring *Keyring, rules DecodeRules) (map[string]string, error) {
+	values := make(map[string]string, len(layers))
+	for k, l := range layers {
+		v := l.Value
+		if keyring != nil {
+			var err error
+			if v, err = keyring.Decrypt(v); err != nil {
+				return nil, fmt.Errorf("%s: %s", k, err.Error())
+			}
+		}
+		value, err := rules.Decode(k, v)
+		if err != nil {
+			return nil, err
+		}
+		values[k] = value
+	}
+	return values, nil
+}
+
+func ResourcePrefixes(globalPrefix, prefix string, prefixes []string) []string {
+	return resourcePrefixes(globalPrefix, prefix, prefixes)
+}
+
+// resourcePrefixes returns the absolute key prefixes of a template resource
+// in priority order: its prefixes if any are set, otherwise its prefix.
+func resourcePrefixes(globalPrefix, prefix string, prefixes []string) []string {
+	if len(prefixes) == 0 {
+		return []string{filepath.Join("/", globalPrefix, prefix)}
+	}
+	ps := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		ps[i] = filepath.Join("/", globalPrefix, p)
+	}
+	return ps
+}
@@ -0,0 +1,118 @@
+package template
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// ErrPendingChanges is returned by Process in noop mode when any target
+// config would be changed.
+var ErrPendingChanges = errors.New("Noop mode enabled. Target configs would be changed")
+
+// noopOutput is where the changes found in noop mode are written.
+var noopOutput io.Writer = os.Stdout
+
+// attrChange describes a file attribute that would change.
+type attrChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// pendingChange describes how a target config would change, and is written
+// as a JSON object in the json noop format.
+type pendingChange struct {
+	Resource string      `json:"resource"`
+	Dest     string      `json:"dest"`
+	Created  bool        `json:"created"`
+	Mode     *attrChange `json:"mode,omitempty"`
+	Uid      *attrChange `json:"uid,omitempty"`
+	Gid      *attrChange `json:"gid,omitempty"`
+	Diff     string      `json:"diff"`
+	Redacted bool        `json:"redacted,omitempty"` // the contents change, but the diff holds decrypted values
+}
+
+// showChanges writes how the target config would change if replaced with
+// the staged file, as text with a unified diff or as JSON depending on the
+// noop format.
+// It returns an error if any.
+func (t *TemplateResource) showChanges(staged string) error {
+	change, err := t.pendingChange(staged)
+	if err != nil {
+		return err
+	}
+	if t.noopFormat == "json" {
+		b, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(noopOutput, "%s\n", b)
+		return err
+	}
+	for _, a := range []struct {
+		name   string
+		change *attrChange
+	}{{"mode", change.Mode}, {"uid", change.Uid}, {"gid", change.Gid}} {
+		if a.change != nil {
+			fmt.Fprintf(noopOutput, "%s %s would change from %s to %s\n", t.Dest, a.name, a.change.From, a.change.To)
+		}
+	}
+	if change.Redacted {
+		_, err = fmt.Fprintf(noopOutput, "%s contents would change, the diff is hidden as the template uses decrypted values\n", t.Dest)
+		return err
+	}
+	_, err = io.WriteString(noopOutput, change.Diff)
+	return err
+}
+
+// pendingChange compares the target config with the staged file.
+func (t *TemplateResource) pendingChange(staged string) (*pendingChange, error) {
+	change := &pendingChange{Resource: t.name, Dest: t.Dest}
+	s, err := fileStat(staged)
+	if err != nil {
+		return nil, err
+	}
+	to, err := ioutil.ReadFile(staged)
+	if err != nil {
+		return nil, err
+	}
+	if !isFileExist(t.Dest) {
+		change.Created = true
+		t.setDiff(change, "/dev/null", t.Dest, "", string(to))
+		return change, nil
+	}
+	d, err := fileStat(t.Dest)
+	if err != nil {
+		return nil, err
+	}
+	from, err := ioutil.ReadFile(t.Dest)
+	if err != nil {
+		return nil, err
+	}
+	if d.Mode != s.Mode {
+		change.Mode = &attrChange{fmt.Sprintf("%04o", d.Mode.Perm()), fmt.Sprintf("%04o", s.Mode.Perm())}
+	}
+	if d.Uid != s.Uid {
+		change.Uid = &attrChange{fmt.Sprint(d.Uid), fmt.Sprint(s.Uid)}
+	}
+	if d.Gid != s.Gid {
+		change.Gid = &attrChange{fmt.Sprint(d.Gid), fmt.Sprint(s.Gid)}
+	}
+	t.setDiff(change, t.Dest, t.Dest+" (rendered)", string(from), string(to))
+	return change, nil
+}
+
+// setDiff sets the unified diff of change from the contents from to the
+// contents to. When the render used decrypted values the diff is left out,
+// so that secrets are not printed, and the change is marked as redacted if
+// the contents differ.
+func (t *TemplateResource) setDiff(change *pendingChange, fromName, toName, from, to string) {
+	if t.decrypted {
+		change.Redacted = from != to
+		return
+	}
+	change.Diff = unifiedDiff(fromName, toName, from, to)
+}
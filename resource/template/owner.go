@@ -0,0 +1,78 @@
+package template
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/BurntSushi/toml"
+)
+
+// resolveOwnership sets Uid and Gid from the owner and group settings, and
+// records which of them are configured. Those that are not are set by
+// setOwnership when the template resource is processed.
+func (t *TemplateResource) resolveOwnership(md toml.MetaData) error {
+	var err error
+	if t.Owner != "" {
+		if t.Uid, err = lookupUid(t.Owner); err != nil {
+			return errors.New("owner: " + err.Error())
+		}
+	}
+	if t.Group != "" {
+		if t.Gid, err = lookupGid(t.Group); err != nil {
+			return errors.New("group: " + err.Error())
+		}
+	}
+	t.uidSet = t.Owner != "" || md.IsDefined("template", "uid")
+	t.gidSet = t.Group != "" || md.IsDefined("template", "gid")
+	return nil
+}
+
+// setOwnership sets the Uid and Gid that are not configured to those of the
+// existing target config, or to those of the confd process if there is none.
+func (t *TemplateResource) setOwnership() error {
+	if t.uidSet && t.gidSet {
+		return nil
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	fi, err := os.Stat(t.Dest)
+	if err == nil {
+		uid = int(fi.Sys().(*syscall.Stat_t).Uid)
+		gid = int(fi.Sys().(*syscall.Stat_t).Gid)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if !t.uidSet {
+		t.Uid = uid
+	}
+	if !t.gidSet {
+		t.Gid = gid
+	}
+	return nil
+}
+
+// lookupUid returns the uid of the user with the given name or numeric id.
+func lookupUid(name string) (int, error) {
+	if uid, err := strconv.Atoi(name); err == nil {
+		return uid, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// lookupGid returns the gid of the group with the given name or numeric id.
+func lookupGid(name string) (int, error) {
+	if gid, err := strconv.Atoi(name); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
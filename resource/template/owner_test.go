@@ -0,0 +1,114 @@
+package template
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wuranbo/confd/backends/env"
+)
+
+func TestLookupUid(t *testing.T) {
+	for _, name := range []string{"root", "0"} {
+		uid, err := lookupUid(name)
+		if err != nil {
+			t.Fatalf("lookupUid(%q) failed: %s", name, err.Error())
+		}
+		if uid != 0 {
+			t.Errorf("lookupUid(%q) = %d, want 0", name, uid)
+		}
+	}
+	if _, err := lookupUid("confd-no-such-user"); err == nil {
+		t.Error("Expected lookupUid of an unknown user to fail")
+	}
+}
+
+func TestLookupGid(t *testing.T) {
+	for _, name := range []string{"root", "0"} {
+		gid, err := lookupGid(name)
+		if err != nil {
+			t.Fatalf("lookupGid(%q) failed: %s", name, err.Error())
+		}
+		if gid != 0 {
+			t.Errorf("lookupGid(%q) = %d, want 0", name, gid)
+		}
+	}
+	if _, err := lookupGid("confd-no-such-group"); err == nil {
+		t.Error("Expected lookupGid of an unknown group to fail")
+	}
+}
+
+var ownerResourceConfig = `
+[template]
+src = "app.tmpl"
+dest = "/etc/app.conf"
+owner = "root"
+gid = 0
+`
+
+func TestNewTemplateResourceOwnership(t *testing.T) {
+	f, err := ioutil.TempFile("", "resource")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(ownerResourceConfig); err != nil {
+		t.Fatal(err.Error())
+	}
+	f.Close()
+	storeClient, err := env.NewEnvClient()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tr, err := NewTemplateResource(f.Name(), Config{StoreClient: storeClient})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !tr.uidSet || !tr.gidSet {
+		t.Errorf("Expected owner and gid to be set, got uidSet %t, gidSet %t", tr.uidSet, tr.gidSet)
+	}
+	if tr.Uid != 0 || tr.Gid != 0 {
+		t.Errorf("Expected uid 0 and gid 0, got %d and %d", tr.Uid, tr.Gid)
+	}
+}
+
+func TestSetOwnershipNewFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	tr := &TemplateResource{Dest: filepath.Join(dir, "app.conf")}
+	if err := tr.setOwnership(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if tr.Uid != os.Getuid() || tr.Gid != os.Getgid() {
+		t.Errorf("Expected uid %d and gid %d, got %d and %d", os.Getuid(), os.Getgid(), tr.Uid, tr.Gid)
+	}
+}
+
+func TestSetOwnershipKeepsExistingOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of a file requires root")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	dest := filepath.Join(dir, "app.conf")
+	if err := ioutil.WriteFile(dest, []byte("old"), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := os.Chown(dest, 65534, 65534); err != nil {
+		t.Fatal(err.Error())
+	}
+	tr := &TemplateResource{Dest: dest, Gid: 1, gidSet: true}
+	if err := tr.setOwnership(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if tr.Uid != 65534 || tr.Gid != 1 {
+		t.Errorf("Expected uid 65534 and gid 1, got %d and %d", tr.Uid, tr.Gid)
+	}
+}
@@ -12,22 +12,40 @@ type Processor interface {
 	Process()
 }
 
+// Process processes every template resource once. In noop mode it returns
+// ErrPendingChanges if any target config would be changed.
 func Process(config Config) error {
 	ts, err := getTemplateResources(config)
 	if err != nil {
 		return err
 	}
-	return process(ts)
+	if err := process(ts); err != nil {
+		return err
+	}
+	for _, t := range ts {
+		if t.pending {
+			return ErrPendingChanges
+		}
+	}
+	return nil
 }
 
+// process processes ts, then runs the reloads of the updated template
+// resources so that each distinct reload command runs once.
 func process(ts []*TemplateResource) error {
 	var lastErr error
+	reloads := newReloadQueue()
 	for _, t := range ts {
+		t.reloads = reloads
 		if err := t.process(); err != nil {
 			log.Error(err.Error())
 			lastErr = err
 		}
 	}
+	for _, err := range reloads.run() {
+		log.Error(err.Error())
+		lastErr = err
+	}
 	return lastErr
 }
 
@@ -53,7 +71,7 @@ func (p *intervalProcessor) Process() {
 		process(ts)
 		select {
 		case <-p.stopChan:
-			break
+			return
 		case <-time.After(time.Duration(p.interval) * time.Second):
 			continue
 		}
@@ -66,11 +84,11 @@ type watchProcessor struct {
 	doneChan chan bool
 	errChan  chan error
 	wg       sync.WaitGroup
+	reloads  *reloadQueue
 }
 
 func WatchProcessor(config Config, stopChan, doneChan chan bool, errChan chan error) Processor {
-	var wg sync.WaitGroup
-	return &watchProcessor{config, stopChan, doneChan, errChan, wg}
+	return &watchProcessor{config: config, stopChan: stopChan, doneChan: doneChan, errChan: errChan, reloads: newReloadQueue()}
 }
 
 func (p *watchProcessor) Process() {
@@ -80,27 +98,54 @@ func (p *watchProcessor) Process() {
 		return
 	}
 	for _, t := range ts {
-		t := t
-		p.wg.Add(1)
-		go p.monitorPrefix(t)
+		t.reloads = p.reloads
+		for _, prefix := range t.prefixes {
+			p.wg.Add(1)
+			go p.monitorPrefix(t, prefix)
+		}
 	}
+	<-p.stopChan
 	p.wg.Wait()
+	// Once every monitor has returned no more reloads are queued. Reload the
+	// target configs already replaced instead of waiting for the debounce
+	// window, or they would stay unreloaded with their rollback copies left
+	// behind.
+	for _, err := range p.reloads.flush() {
+		p.errChan <- err
+	}
 }
 
-func (p *watchProcessor) monitorPrefix(t *TemplateResource) {
+func (p *watchProcessor) monitorPrefix(t *TemplateResource, prefix string) {
 	defer p.wg.Done()
+	var lastIndex uint64
 	for {
-		index, err := t.storeClient.WatchPrefix(t.Prefix, t.lastIndex, p.stopChan)
+		index, err := t.storeClient.WatchPrefix(prefix, lastIndex, p.stopChan)
+		if p.stopped() {
+			return
+		}
 		if err != nil {
 			p.errChan <- err
 			// Prevent backend errors from consuming all resources.
 			time.Sleep(time.Second * 2)
 			continue
 		}
-		t.lastIndex = index
+		lastIndex = index
 		if err := t.process(); err != nil {
 			p.errChan <- err
 		}
+		// Changes often come in bursts touching several resources, reload
+		// once they are all written.
+		p.reloads.schedule(p.config.ReloadDebounce, p.errChan)
+	}
+}
+
+// stopped reports whether the processor was asked to stop.
+func (p *watchProcessor) stopped() bool {
+	select {
+	case <-p.stopChan:
+		return true
+	default:
+		return false
 	}
 }
 
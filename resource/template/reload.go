@@ -0,0 +1,226 @@
+package template
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wuranbo/confd/log"
+)
+
+// queuedReload is the reload of a template resource whose target config was
+// replaced, waiting to run along with the reloads of other resources.
+type queuedReload struct {
+	t      *TemplateResource
+	backup *destBackup // the previous target config, restored if the reload fails
+}
+
+// reloadQueue collects the reloads of updated template resources, so that
+// each distinct reload command runs once after all the target configs it
+// reloads are written.
+type reloadQueue struct {
+	mu        sync.Mutex
+	runMu     sync.Mutex // serializes run
+	queued    []queuedReload
+	timer     *time.Timer
+	scheduled sync.WaitGroup // counts the scheduled runs not finished yet
+	stopped   bool           // set by flush, after which schedule does nothing
+}
+
+func newReloadQueue() *reloadQueue {
+	return &reloadQueue{}
+}
+
+// add queues the reload of q.t. If the resource is queued already, its
+// first backup is kept, as it holds the last config that was reloaded.
+func (r *reloadQueue) add(q queuedReload) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, queued := range r.queued {
+		if queued.t == q.t {
+			q.backup.remove()
+			return
+		}
+	}
+	r.queued = append(r.queued, q)
+}
+
+// schedule runs the queued reloads once no reload was scheduled for the
+// duration window, sending their errors to errChan. It does nothing once the
+// queue is flushed.
+func (r *reloadQueue) schedule(window time.Duration, errChan chan error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	r.cancel()
+	r.scheduled.Add(1)
+	r.timer = time.AfterFunc(window, func() {
+		defer r.scheduled.Done()
+		for _, err := range r.run() {
+			errChan <- err
+		}
+	})
+}
+
+// cancel stops the scheduled run, if it has not started. r.mu must be held.
+func (r *reloadQueue) cancel() {
+	if r.timer != nil && r.timer.Stop() {
+		r.scheduled.Done()
+	}
+	r.timer = nil
+}
+
+// wait waits for the scheduled run to finish.
+func (r *reloadQueue) wait() {
+	r.scheduled.Wait()
+}
+
+// flush cancels the scheduled run of the queued reloads and runs them right
+// away, so the target configs already replaced are reloaded before confd
+// exits. Later calls to schedule do nothing. It waits for a scheduled run
+// that already started to finish.
+// It returns the errors of the template resources whose reload failed.
+func (r *reloadQueue) flush() []error {
+	r.mu.Lock()
+	r.stopped = true
+	r.cancel()
+	r.mu.Unlock()
+	errs := r.run()
+	r.wait()
+	return errs
+}
+
+// len returns the number of queued reloads.
+func (r *reloadQueue) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.queued)
+}
+
+// run runs the queued reloads, each distinct reload command once.
+// It returns the errors of the template resources whose reload failed.
+func (r *reloadQueue) run() []error {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+	r.mu.Lock()
+	queued := r.queued
+	r.queued = nil
+	r.mu.Unlock()
+	var errs []error
+	for _, group := range groupReloads(queued) {
+		for _, q := range group {
+			q.t.mu.Lock()
+		}
+		for i, err := range runReloads(group) {
+			if err != nil {
+				errs = append(errs, group[i].t.failed(err))
+			}
+		}
+		for _, q := range group {
+			q.t.mu.Unlock()
+		}
+	}
+	return errs
+}
+
+// groupReloads groups queued by reload command, keeping the order in which
+// the commands were first queued.
+func groupReloads(queued []queuedReload) [][]queuedReload {
+	var groups [][]queuedReload
+	index := make(map[string]int)
+	for _, q := range queued {
+		key := q.t.reloadKey()
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], q)
+	}
+	return groups
+}
+
+// reloadKey identifies the reload command of the template resource, its
+// timeout and the environment it runs in.
+func (t *TemplateResource) reloadKey() string {
+	keys := make([]string, 0, len(t.Env))
+	for k := range t.Env {
+		keys = append(keys, k+"="+t.Env[k])
+	}
+	sort.Strings(keys)
+	return fmt.Sprintf("%s\x00%s\x00%t\x00%s", t.reloadCmd, t.reloadTimeout, t.ClearEnv, strings.Join(keys, "\x00"))
+}
+
+// runReloads runs the reload command shared by the template resources of
+// group once, then the post sync hook of each. If the reload command fails,
+// the previous target configs are restored and the command is run again.
+// It returns the error of each resource of group.
+func runReloads(group []queuedReload) []error {
+	dests := make([]string, len(group))
+	for i, q := range group {
+		dests[i] = q.t.Dest
+	}
+	errs := make([]error, len(group))
+	if err := group[0].t.reload(); err != nil {
+		return rollback(group, err)
+	}
+	log.Info("Reloaded " + strings.Join(dests, ", "))
+	for i, q := range group {
+		q.backup.remove()
+		if !q.t.postSyncCmd.empty() {
+			errs[i] = q.t.runHook("post_sync_cmd", q.t.postSyncCmd)
+		}
+	}
+	return errs
+}
+
+// rollback restores the target configs saved before the reload of group
+// failed with reloadErr, and runs the reload command again so the service is
+// back on the previous configs. If that reload succeeds, the post sync hook of
+// each restored resource runs, so that whatever the pre sync hook did is
+// undone.
+// It returns the error of each resource of group, describing the outcome.
+func rollback(group []queuedReload, reloadErr error) []error {
+	errs := make([]error, len(group))
+	restored := make([]string, 0, len(group))
+	for i, q := range group {
+		log.Error("Reload failed, restoring the previous " + q.t.Dest + " - " + reloadErr.Error())
+		if err := q.backup.restore(); err != nil {
+			log.Error("Cannot restore the previous " + q.t.Dest + " - " + err.Error())
+			errs[i] = fmt.Errorf("Reload failed: %s; restoring %s failed: %s", reloadErr.Error(), q.t.Dest, err.Error())
+			continue
+		}
+		q.backup.remove()
+		restored = append(restored, q.t.Dest)
+	}
+	if len(restored) == 0 {
+		return errs
+	}
+	if err := group[0].t.reload(); err != nil {
+		log.Error("Reload of the restored " + strings.Join(restored, ", ") + " failed - " + err.Error())
+		for i, q := range group {
+			if errs[i] == nil {
+				errs[i] = fmt.Errorf("Reload failed: %s; reload of the restored %s failed: %s", reloadErr.Error(), q.t.Dest, err.Error())
+			}
+		}
+		return errs
+	}
+	log.Warning("Restored and reloaded the previous " + strings.Join(restored, ", "))
+	for i, q := range group {
+		if errs[i] != nil {
+			continue
+		}
+		errs[i] = fmt.Errorf("Reload failed, previous %s restored: %s", q.t.Dest, reloadErr.Error())
+		if !q.t.postSyncCmd.empty() {
+			if err := q.t.runHook("post_sync_cmd", q.t.postSyncCmd); err != nil {
+				log.Error(err.Error())
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,214 @@
+package template
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wuranbo/confd/backends/env"
+	"github.com/wuranbo/confd/log"
+)
+
+var sharedReloadResourceConfig = `
+[template]
+src = "app.tmpl"
+dest = "%s"
+keys = [
+  "/",
+]
+reload_cmd = "%s"
+`
+
+// processSharedReload renders "new" over dests holding "old" in a new
+// temporary directory, each with the given reload command in which %s is
+// replaced by that directory, and returns the directory and the error of
+// Process.
+func processSharedReload(t *testing.T, reloadCmds ...string) (string, error) {
+	dir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	var resources []string
+	for i, reloadCmd := range reloadCmds {
+		destFile := filepath.Join(dir, fmt.Sprintf("app%d.conf", i))
+		if err := ioutil.WriteFile(destFile, []byte("old"), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+		resources = append(resources, fmt.Sprintf(sharedReloadResourceConfig, destFile, strings.Replace(reloadCmd, "%s", dir, -1)))
+	}
+	return dir, processTestResources(t, dir, "new", nil, resources...)
+}
+
+func readFile(t *testing.T, path string) string {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return string(contents)
+}
+
+func TestProcessCoalescesReloads(t *testing.T) {
+	log.SetQuiet(true)
+	// The reloads record the dests they see, so they must run after all of
+	// them are written.
+	shared := "cat %s/app0.conf %s/app1.conf >> %s/shared.log; echo >> %s/shared.log"
+	other := "echo other >> %s/other.log"
+	dir, err := processSharedReload(t, shared, shared, other)
+	defer os.RemoveAll(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if actual := readFile(t, filepath.Join(dir, "shared.log")); actual != "newnew\n" {
+		t.Errorf("Expected the shared reload to run once after both dests were written, got %q", actual)
+	}
+	if actual := readFile(t, filepath.Join(dir, "other.log")); actual != "other\n" {
+		t.Errorf("Expected the other reload to run once, got %q", actual)
+	}
+}
+
+func TestProcessRollsBackCoalescedReload(t *testing.T) {
+	log.SetQuiet(true)
+	// The reload only succeeds once both dests are restored.
+	shared := "grep -q old %s/app0.conf && grep -q old %s/app1.conf"
+	dir, err := processSharedReload(t, shared, shared)
+	defer os.RemoveAll(dir)
+	if err == nil {
+		t.Errorf("Expected Process to fail when the reload fails")
+	}
+	for i := 0; i < 2; i++ {
+		if actual := readFile(t, filepath.Join(dir, fmt.Sprintf("app%d.conf", i))); actual != "old" {
+			t.Errorf("Expected app%d.conf to be restored, got %s", i, actual)
+		}
+	}
+}
+
+func TestReloadQueueDebounce(t *testing.T) {
+	log.SetQuiet(true)
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	reloadLog := filepath.Join(dir, "reload.log")
+	reloads := newReloadQueue()
+	defer reloads.wait()
+	errChan := make(chan error, 10)
+	reloadCmd := command{line: "echo reload >> " + reloadLog}
+	a := &TemplateResource{Dest: filepath.Join(dir, "a.conf"), reloadCmd: reloadCmd}
+	b := &TemplateResource{Dest: filepath.Join(dir, "b.conf"), reloadCmd: reloadCmd}
+	// Each change within the window pushes the reload back.
+	for _, tr := range []*TemplateResource{a, b, a} {
+		reloads.add(queuedReload{tr, &destBackup{dest: tr.Dest}})
+		reloads.schedule(time.Hour, errChan)
+	}
+	if n := reloads.len(); n != 2 {
+		t.Errorf("Expected 2 queued reloads, got %d", n)
+	}
+	if isFileExist(reloadLog) {
+		t.Errorf("Expected the reload to wait for the debounce window")
+	}
+	reloads.schedule(time.Millisecond, errChan)
+	reloads.wait()
+	if actual := readFile(t, reloadLog); actual != "reload\n" {
+		t.Errorf("Expected a single reload, got %q", actual)
+	}
+	if n := reloads.len(); n != 0 {
+		t.Errorf("Expected the queue to be empty, got %d queued reloads", n)
+	}
+	select {
+	case err := <-errChan:
+		t.Errorf("Unexpected error: %s", err.Error())
+	default:
+	}
+}
+
+func TestReloadQueueFlushStopsScheduling(t *testing.T) {
+	log.SetQuiet(true)
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	reloadLog := filepath.Join(dir, "reload.log")
+	reloads := newReloadQueue()
+	errChan := make(chan error, 10)
+	tr := &TemplateResource{Dest: filepath.Join(dir, "a.conf"), reloadCmd: command{line: "echo reload >> " + reloadLog}}
+	reloads.add(queuedReload{tr, &destBackup{dest: tr.Dest}})
+	reloads.schedule(time.Hour, errChan)
+	if errs := reloads.flush(); len(errs) != 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+	if actual := readFile(t, reloadLog); actual != "reload\n" {
+		t.Errorf("Expected the flush to run the reload, got %q", actual)
+	}
+	reloads.add(queuedReload{tr, &destBackup{dest: tr.Dest}})
+	reloads.schedule(time.Millisecond, errChan)
+	reloads.wait()
+	if actual := readFile(t, reloadLog); actual != "reload\n" {
+		t.Errorf("Expected no reload to be scheduled after the flush, got %q", actual)
+	}
+}
+
+func TestGroupReloadsByTimeout(t *testing.T) {
+	reloadCmd := command{line: "systemctl reload app"}
+	a := &TemplateResource{Dest: "a.conf", reloadCmd: reloadCmd, reloadTimeout: time.Second}
+	b := &TemplateResource{Dest: "b.conf", reloadCmd: reloadCmd, reloadTimeout: time.Second}
+	c := &TemplateResource{Dest: "c.conf", reloadCmd: reloadCmd, reloadTimeout: time.Minute}
+	groups := groupReloads([]queuedReload{{t: a}, {t: c}, {t: b}})
+	if len(groups) != 2 || len(groups[0]) != 2 || len(groups[1]) != 1 {
+		t.Fatalf("Expected the reloads with different timeouts to run separately, got %v", groups)
+	}
+	if groups[0][1].t != b || groups[1][0].t != c {
+		t.Errorf("Expected a and b to share a reload, got %v", groups)
+	}
+}
+
+func TestWatchProcessorRunsPendingReloadsOnStop(t *testing.T) {
+	log.SetQuiet(true)
+	dir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	storeClient, err := env.NewEnvClient()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	c := Config{
+		ConfDir:        dir,
+		ConfigDir:      filepath.Join(dir, "conf.d"),
+		ReloadDebounce: time.Hour,
+		StoreClient:    storeClient,
+		TemplateDir:    filepath.Join(dir, "templates"),
+	}
+	stopChan := make(chan bool)
+	doneChan := make(chan bool)
+	errChan := make(chan error, 10)
+	p := WatchProcessor(c, stopChan, doneChan, errChan).(*watchProcessor)
+	reloadLog := filepath.Join(dir, "reload.log")
+	tr := &TemplateResource{
+		Dest:      filepath.Join(dir, "app.conf"),
+		reloadCmd: command{line: "echo reload >> " + reloadLog},
+	}
+	p.reloads.add(queuedReload{tr, &destBackup{dest: tr.Dest}})
+	p.reloads.schedule(c.ReloadDebounce, errChan)
+	go p.Process()
+	close(stopChan)
+	select {
+	case <-doneChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the processor to stop")
+	}
+	if actual := readFile(t, reloadLog); actual != "reload\n" {
+		t.Errorf("Expected the pending reload to run once on stop, got %q", actual)
+	}
+	select {
+	case err := <-errChan:
+		t.Errorf("Unexpected error: %s", err.Error())
+	default:
+	}
+}
@@ -1,32 +1,36 @@
 package template
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"text/template"
+	"sync"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/kelseyhightower/memkv"
 	"github.com/wuranbo/confd/backends"
 	"github.com/wuranbo/confd/log"
-	"github.com/kelseyhightower/memkv"
 )
 
 type Config struct {
-	ConfDir       string
-	ConfigDir     string
-	KeepStageFile bool
-	Noop          bool
-	Prefix        string
-	StoreClient   backends.StoreClient
-	TemplateDir   string
+	ConfDir        string
+	ConfigDir      string
+	HostFacts      *HostFacts
+	KeepStageFile  bool
+	Keyring        *Keyring
+	Noop           bool
+	NoopFormat     string // diff or json
+	PartialDir     string
+	Prefix         string
+	ReloadDebounce time.Duration // how long watch mode waits for more changes before reloading
+	StoreClient    backends.StoreClient
+	TemplateDir    string
 }
 
 // TemplateResourceConfig holds the parsed template resource.
@@ -36,24 +40,72 @@ type TemplateResourceConfig struct {
 
 // TemplateResource is the representation of a parsed template resource.
 type TemplateResource struct {
-	CheckCmd      string `toml:"check_cmd"`
-	Dest          string
-	FileMode      os.FileMode
-	Gid           int
-	Keys          []string
-	Mode          string
-	Prefix        string
-	ReloadCmd     string `toml:"reload_cmd"`
-	Src           string
-	StageFile     *os.File
-	Uid           int
-	funcMap       map[string]interface{}
-	lastIndex     uint64
-	keepStageFile bool
-	noop          bool
-	prefix        string
-	store         memkv.Store
-	storeClient   backends.StoreClient
+	BackupDir      string      `toml:"backup_dir"`
+	BackupKeep     int         `toml:"backup_keep"`
+	CheckCmd       interface{} `toml:"check_cmd"` // string or array of strings
+	CheckTimeout   string      `toml:"check_timeout"`
+	ClearEnv       bool        `toml:"clear_env"`
+	CreateDirs     bool        `toml:"create_dirs"`
+	Decode         DecodeRules
+	Dest           string
+	DirGroup       string `toml:"dir_group"`
+	DirMode        string `toml:"dir_mode"`
+	DirOwner       string `toml:"dir_owner"`
+	Env            map[string]string
+	FileMode       os.FileMode
+	FixedTime      string `toml:"fixed_time"`
+	FollowSymlinks bool   `toml:"follow_symlinks"`
+	Gid            int
+	Group          string
+	HookTimeout    string `toml:"hook_timeout"`
+	Keys           []string
+	LeftDelimiter  string `toml:"left_delimiter"`
+	Mode           string
+	MountedDest    string      `toml:"mounted_dest"` // overwrite or fail
+	OnErrorCmd     interface{} `toml:"on_error_cmd"` // string or array of strings
+	Owner          string
+	PostSyncCmd    interface{} `toml:"post_sync_cmd"` // string or array of strings
+	PreSyncCmd     interface{} `toml:"pre_sync_cmd"`  // string or array of strings
+	Prefix         string
+	Prefixes       []string
+	ReloadCmd      interface{} `toml:"reload_cmd"` // string or array of strings
+	ReloadTimeout  string      `toml:"reload_timeout"`
+	RightDelimiter string      `toml:"right_delimiter"`
+	Src            string
+	StageFile      *os.File
+	Strict         bool
+	Uid            int
+	Vars           map[string]interface{}
+	funcMap        map[string]interface{}
+	checkCmd       command
+	checkTimeout   time.Duration
+	decrypted      bool // whether the last render used a decrypted value
+	dirGid         int // -1 keeps the group of confd
+	dirMode        os.FileMode
+	dirUid         int  // -1 keeps the owner of confd
+	gidSet         bool // whether Gid is configured rather than taken from the target config
+	hookTimeout    time.Duration
+	hostFacts      *HostFacts
+	layers         memkv.Store
+	name           string
+	mu             sync.Mutex // serializes process when several prefixes are watched
+	keepStageFile  bool
+	keyring        *Keyring
+	noop           bool
+	noopFormat     string
+	onErrorCmd     command
+	overwriteDest  bool // whether a mounted target config is overwritten in place
+	partialDir     string
+	pending        bool // whether the target config would change in noop mode
+	postSyncCmd    command
+	preSyncCmd     command
+	prefixes       []string
+	reloadCmd      command
+	reloads        *reloadQueue // queues reloads instead of running them, if set
+	reloadTimeout  time.Duration
+	store          memkv.Store
+	storeClient    backends.StoreClient
+	uidSet         bool // whether Uid is configured rather than taken from the target config
 }
 
 var ErrEmptySrc = errors.New("empty src template")
@@ -65,18 +117,81 @@ func NewTemplateResource(path string, config Config) (*TemplateResource, error)
 	}
 	var tc *TemplateResourceConfig
 	log.Debug("Loading template resource from " + path)
-	_, err := toml.DecodeFile(path, &tc)
+	md, err := toml.DecodeFile(path, &tc)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot process template resource %s - %s", path, err.Error())
 	}
 	tr := tc.TemplateResource
+	tr.name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
 	tr.keepStageFile = config.KeepStageFile
+	tr.keyring = config.Keyring
 	tr.noop = config.Noop
+	tr.noopFormat = config.NoopFormat
+	tr.partialDir = config.PartialDir
 	tr.storeClient = config.StoreClient
+	tr.hostFacts = config.HostFacts
+	if tr.hostFacts == nil {
+		tr.hostFacts = LocalHostFacts()
+	}
 	tr.funcMap = newFuncMap()
+	addFuncs(tr.funcMap, tr.hostFacts.FuncMap())
+	addFuncs(tr.funcMap, map[string]interface{}{"decrypt": tr.decrypt})
 	tr.store = memkv.New()
-	addFuncs(tr.funcMap, tr.store.FuncMap)
-	tr.prefix = filepath.Join("/", config.Prefix, tr.Prefix)
+	tr.layers = memkv.New()
+	addFuncs(tr.funcMap, newStoreFuncMap(&tr.store, &tr.layers, tr.Strict))
+	if tr.FixedTime != "" {
+		timeFuncs, err := FixedTimeFuncMap(tr.FixedTime)
+		if err != nil {
+			return nil, err
+		}
+		addFuncs(tr.funcMap, timeFuncs)
+	}
+	if tr.Vars, err = interpolateVars(tr.Vars, tr.hostFacts); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource %s - vars: %s", path, err.Error())
+	}
+	if err := tr.Decode.Validate(); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource %s - %s", path, err.Error())
+	}
+	if err := tr.interpolateSettings(); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource %s - %s", path, err.Error())
+	}
+	tr.prefixes = resourcePrefixes(config.Prefix, tr.Prefix, tr.Prefixes)
+	if tr.checkCmd, err = parseCommand(tr.CheckCmd); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource %s - check_cmd: %s", path, err.Error())
+	}
+	if tr.reloadCmd, err = parseCommand(tr.ReloadCmd); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource %s - reload_cmd: %s", path, err.Error())
+	}
+	if tr.preSyncCmd, err = parseCommand(tr.PreSyncCmd); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource %s - pre_sync_cmd: %s", path, err.Error())
+	}
+	if tr.postSyncCmd, err = parseCommand(tr.PostSyncCmd); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource %s - post_sync_cmd: %s", path, err.Error())
+	}
+	if tr.onErrorCmd, err = parseCommand(tr.OnErrorCmd); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource %s - on_error_cmd: %s", path, err.Error())
+	}
+	if tr.hookTimeout, err = parseTimeout(tr.HookTimeout); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource %s - hook_timeout: %s", path, err.Error())
+	}
+	if tr.checkTimeout, err = parseTimeout(tr.CheckTimeout); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource %s - check_timeout: %s", path, err.Error())
+	}
+	if tr.reloadTimeout, err = parseTimeout(tr.ReloadTimeout); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource %s - reload_timeout: %s", path, err.Error())
+	}
+	if err := tr.resolveOwnership(md); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource %s - %s", path, err.Error())
+	}
+	if tr.overwriteDest, err = parseMountedDest(tr.MountedDest); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource %s - mounted_dest: %s", path, err.Error())
+	}
+	if err := tr.parseDirSettings(); err != nil {
+		return nil, fmt.Errorf("Cannot process template resource %s - %s", path, err.Error())
+	}
+	if tr.BackupKeep <= 0 {
+		tr.BackupKeep = defaultBackupKeep
+	}
 	if tr.Src == "" {
 		return nil, ErrEmptySrc
 	}
@@ -84,22 +199,82 @@ func NewTemplateResource(path string, config Config) (*TemplateResource, error)
 	return &tr, nil
 }
 
-// setVars sets the Vars for template resource.
-func (t *TemplateResource) setVars() error {
+// parseTimeout parses a command timeout like "30s", an empty timeout
+// meaning no limit.
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// parseMountedDest parses the mounted_dest setting, and reports whether a
+// target config that cannot be renamed over is overwritten in place.
+func parseMountedDest(s string) (bool, error) {
+	switch s {
+	case "", "overwrite":
+		return true, nil
+	case "fail":
+		return false, nil
+	}
+	return false, errors.New("invalid value " + s + ", must be overwrite or fail")
+}
+
+// interpolateSettings evaluates the template syntax in the prefix, prefixes,
+// keys and dest settings with the environment and host facts.
+func (t *TemplateResource) interpolateSettings() error {
 	var err error
+	if t.Prefix, err = interpolate(t.Prefix, t.hostFacts); err != nil {
+		return errors.New("prefix: " + err.Error())
+	}
+	if err = interpolateAll(t.Prefixes, t.hostFacts); err != nil {
+		return errors.New("prefixes: " + err.Error())
+	}
+	if err = interpolateAll(t.Keys, t.hostFacts); err != nil {
+		return errors.New("keys: " + err.Error())
+	}
+	if t.Dest, err = interpolate(t.Dest, t.hostFacts); err != nil {
+		return errors.New("dest: " + err.Error())
+	}
+	return nil
+}
+
+// setVars sets the Vars for template resource. When the resource has several
+// prefixes, the value of a key is taken from the first prefix defining it.
+// Encrypted values are decrypted when a keyring is configured.
+func (t *TemplateResource) setVars() error {
 	log.Debug("Retrieving keys from store")
-	log.Debug("Key prefix set to " + t.prefix)
-	result, err := t.storeClient.GetValues(appendPrefix(t.prefix, t.Keys))
+	log.Debug("Key prefixes set to " + strings.Join(t.prefixes, ", "))
+	layers, err := loadLayers(t.storeClient, t.prefixes, t.Keys)
 	if err != nil {
 		return err
 	}
+	values, err := decodeLayers(layers, t.keyring, t.Decode)
+	if err != nil {
+		return err
+	}
+	t.decrypted = false
 	t.store.Purge()
-	for k, v := range result {
-		t.store.Set(filepath.Join("/", strings.TrimPrefix(k, t.prefix)), v)
+	t.layers.Purge()
+	for k, l := range layers {
+		if t.keyring != nil && strings.HasPrefix(l.Value, EncryptedPrefix) {
+			t.decrypted = true
+		}
+		t.store.Set(k, values[k])
+		t.layers.Set(k, l.Prefix)
 	}
 	return nil
 }
 
+// decrypt is the decrypt template function of the resource. It records
+// that the render used a decrypted value, so that noop mode does not show it.
+func (t *TemplateResource) decrypt(value string) (string, error) {
+	if strings.HasPrefix(value, EncryptedPrefix) {
+		t.decrypted = true
+	}
+	return t.keyring.Decrypt(value)
+}
+
 // createStageFile stages the src configuration file by processing the src
 // template and setting the desired owner, group, and mode. It also sets the
 // StageFile for the template resource.
@@ -109,15 +284,40 @@ func (t *TemplateResource) createStageFile() error {
 	if !isFileExist(t.Src) {
 		return errors.New("Missing template: " + t.Src)
 	}
-	// create TempFile in Dest directory to avoid cross-filesystem issues
-	temp, err := ioutil.TempFile(filepath.Dir(t.Dest), "."+filepath.Base(t.Dest))
+	log.Debug("Compiling source template " + t.Src)
+	partials, err := loadPartials(t.partialDir)
+	if err != nil {
+		return err
+	}
+	src, err := ioutil.ReadFile(t.Src)
+	if err != nil {
+		return err
+	}
+	tmpl, err := newTemplate(path.Base(t.Src), string(src), t.funcMap, TemplateOptions{
+		LeftDelim:  t.LeftDelimiter,
+		RightDelim: t.RightDelimiter,
+		Strict:     t.Strict,
+		Partials:   partials,
+	})
+	if err != nil {
+		return err
+	}
+	target, err := t.targetPath()
+	if err != nil {
+		return err
+	}
+	// create TempFile in the target directory to avoid cross-filesystem issues
+	dir, err := t.stageDir(target)
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(dir, "."+filepath.Base(target))
 	if err != nil {
 		return err
 	}
 	defer temp.Close()
-	log.Debug("Compiling source template " + t.Src)
-	tmpl := template.Must(template.New(path.Base(t.Src)).Funcs(t.funcMap).ParseFiles(t.Src))
-	if err = tmpl.Execute(temp, nil); err != nil {
+	if err = tmpl.Execute(temp, t.Vars); err != nil {
+		os.Remove(temp.Name())
 		return err
 	}
 	// Set the owner, group, and mode on the stage file now to make it easier to
@@ -146,42 +346,19 @@ func (t *TemplateResource) sync() error {
 		log.Error(err.Error())
 	}
 	if t.noop {
+		t.pending = !ok
+		if t.pending {
+			if err := t.showChanges(staged); err != nil {
+				log.Error(err.Error())
+			}
+		}
 		log.Warning("Noop mode enabled. " + t.Dest + " will not be modified")
 		return nil
 	}
 	if !ok {
 		log.Info("Target config " + t.Dest + " out of sync")
-		if t.CheckCmd != "" {
-			if err := t.check(); err != nil {
-				return errors.New("Config check failed: " + err.Error())
-			}
-		}
-		log.Debug("Overwriting target config " + t.Dest)
-		err := os.Rename(staged, t.Dest)
-		if err != nil {
-			if strings.Contains(err.Error(), "device or resource busy") {
-				log.Debug("Rename failed - target is likely a mount. Trying to write instead")
-				// try to open the file and write to it
-				var contents []byte
-				var rerr error
-				contents, rerr = ioutil.ReadFile(staged)
-				if rerr != nil {
-					return rerr
-				}
-				err := ioutil.WriteFile(t.Dest, contents, t.FileMode)
-				// make sure owner and group match the temp file, in case the file was created with WriteFile
-				os.Chown(t.Dest, t.Uid, t.Gid)
-				if err != nil {
-					return err
-				}
-			} else {
-				return err
-			}
-		}
-		if t.ReloadCmd != "" {
-			if err := t.reload(); err != nil {
-				return err
-			}
+		if err := t.update(staged); err != nil {
+			return err
 		}
 		log.Info("Target config " + t.Dest + " has been updated")
 	} else {
@@ -190,6 +367,87 @@ func (t *TemplateResource) sync() error {
 	return nil
 }
 
+// update replaces the target config with the staged file. It runs the check
+// command on the staged file and the pre sync hook first, saves a versioned
+// backup of the target config if backups are enabled, and runs the reload
+// command and the post sync hook after. If the reload command fails, the
+// previous target config is restored and reloaded. When the resource has a
+// reload queue, the reload and the post sync hook are queued instead.
+// It returns an error if any.
+func (t *TemplateResource) update(staged string) error {
+	if !t.checkCmd.empty() {
+		if err := t.check(); err != nil {
+			return errors.New("Config check failed: " + err.Error())
+		}
+	}
+	if !t.preSyncCmd.empty() {
+		if err := t.runHook("pre_sync_cmd", t.preSyncCmd, "CONFD_STAGED="+staged); err != nil {
+			return err
+		}
+	}
+	if t.BackupDir != "" {
+		if err := t.saveBackup(); err != nil {
+			return errors.New("Cannot back up " + t.Dest + " - " + err.Error())
+		}
+	}
+	target, err := t.targetPath()
+	if err != nil {
+		return err
+	}
+	if t.reloadCmd.empty() {
+		log.Debug("Overwriting target config " + target)
+		if err := replaceFile(staged, target, t.FileMode, t.Uid, t.Gid, t.overwriteDest); err != nil {
+			return err
+		}
+		if !t.postSyncCmd.empty() {
+			return t.runHook("post_sync_cmd", t.postSyncCmd)
+		}
+		return nil
+	}
+	backup, err := backupDest(target)
+	if err != nil {
+		return err
+	}
+	log.Debug("Overwriting target config " + target)
+	if err := replaceFile(staged, target, t.FileMode, t.Uid, t.Gid, t.overwriteDest); err != nil {
+		backup.remove()
+		return err
+	}
+	q := queuedReload{t, backup}
+	if t.reloads != nil {
+		t.reloads.add(q)
+		return nil
+	}
+	return runReloads([]queuedReload{q})[0]
+}
+
+// runHook runs the hook command named name. The name of the template
+// resource and the target config are passed in the CONFD_RESOURCE and
+// CONFD_DEST environment variables, along with env.
+// It returns an error if the hook fails.
+func (t *TemplateResource) runHook(name string, hook command, env ...string) error {
+	env = append(append(commandEnv(t.Env, t.ClearEnv),
+		"CONFD_RESOURCE="+t.name,
+		"CONFD_DEST="+t.Dest,
+	), env...)
+	log.Debug("Running " + name + " " + hook.String())
+	if err := runCommand(hook.cmd(), env, t.hookTimeout); err != nil {
+		return errors.New(name + " failed: " + err.Error())
+	}
+	return nil
+}
+
+// failed runs the on error hook, unless in noop mode, and returns err.
+func (t *TemplateResource) failed(err error) error {
+	if t.onErrorCmd.empty() || t.noop {
+		return err
+	}
+	if hookErr := t.runHook("on_error_cmd", t.onErrorCmd, "CONFD_ERROR="+err.Error()); hookErr != nil {
+		log.Error(hookErr.Error())
+	}
+	return err
+}
+
 // check executes the check command to validate the staged config file. The
 // command is modified so that any references to src template are substituted
 // with a string representing the full path of the staged file. This allows the
@@ -197,37 +455,21 @@ func (t *TemplateResource) sync() error {
 // file.
 // It returns nil if the check command returns 0 and there are no other errors.
 func (t *TemplateResource) check() error {
-	var cmdBuffer bytes.Buffer
 	data := make(map[string]string)
 	data["src"] = t.StageFile.Name()
-	tmpl, err := template.New("checkcmd").Parse(t.CheckCmd)
+	cmd, err := t.checkCmd.render(data)
 	if err != nil {
 		return err
 	}
-	if err := tmpl.Execute(&cmdBuffer, data); err != nil {
-		return err
-	}
-	log.Debug("Running " + cmdBuffer.String())
-	c := exec.Command("/bin/sh", "-c", cmdBuffer.String())
-	output, err := c.CombinedOutput()
-	if err != nil {
-		return err
-	}
-	log.Debug(fmt.Sprintf("%q", string(output)))
-	return nil
+	log.Debug("Running " + cmd.String())
+	return runCommand(cmd.cmd(), commandEnv(t.Env, t.ClearEnv), t.checkTimeout)
 }
 
 // reload executes the reload command.
 // It returns nil if the reload command returns 0.
 func (t *TemplateResource) reload() error {
-	log.Debug("Running " + t.ReloadCmd)
-	c := exec.Command("/bin/sh", "-c", t.ReloadCmd)
-	output, err := c.CombinedOutput()
-	if err != nil {
-		return err
-	}
-	log.Debug(fmt.Sprintf("%q", string(output)))
-	return nil
+	log.Debug("Running " + t.reloadCmd.String())
+	return runCommand(t.reloadCmd.cmd(), commandEnv(t.Env, t.ClearEnv), t.reloadTimeout)
 }
 
 // process is a convenience function that wraps calls to the three main tasks
@@ -236,17 +478,23 @@ func (t *TemplateResource) reload() error {
 // things up.
 // It returns an error if any.
 func (t *TemplateResource) process() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.StageFile = nil
 	if err := t.setFileMode(); err != nil {
-		return err
+		return t.failed(err)
+	}
+	if err := t.setOwnership(); err != nil {
+		return t.failed(err)
 	}
 	if err := t.setVars(); err != nil {
-		return err
+		return t.failed(err)
 	}
 	if err := t.createStageFile(); err != nil {
-		return err
+		return t.failed(err)
 	}
 	if err := t.sync(); err != nil {
-		return err
+		return t.failed(err)
 	}
 	return nil
 }
@@ -272,3 +520,13 @@ func (t *TemplateResource) setFileMode() error {
 	}
 	return nil
 }
+
+// targetPath returns the path of the file replaced to update the target
+// config: Dest or, if FollowSymlinks is set and Dest is a symbolic link, the
+// file it links to.
+func (t *TemplateResource) targetPath() (string, error) {
+	if !t.FollowSymlinks {
+		return t.Dest, nil
+	}
+	return resolveSymlinks(t.Dest)
+}
@@ -1,9 +1,14 @@
 package template
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"text/template"
 
@@ -31,6 +36,41 @@ func createTempDirs() (string, error) {
 	return confDir, nil
 }
 
+// processTestResources writes tmpl to templates/app.tmpl and each of
+// resources to conf.d in dir, a directory created by createTempDirs, and
+// processes them with the env backend. A single resource config is written to
+// app.toml, several to app0.toml, app1.toml and so on. configure, if not nil,
+// adjusts the Config before Process runs.
+// It returns the error of Process.
+func processTestResources(t *testing.T, dir, tmpl string, configure func(*Config), resources ...string) error {
+	if err := ioutil.WriteFile(filepath.Join(dir, "templates", "app.tmpl"), []byte(tmpl), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	for i, resource := range resources {
+		name := "app.toml"
+		if len(resources) > 1 {
+			name = fmt.Sprintf("app%d.toml", i)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "conf.d", name), []byte(resource), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	storeClient, err := env.NewEnvClient()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	c := Config{
+		ConfDir:     dir,
+		ConfigDir:   filepath.Join(dir, "conf.d"),
+		StoreClient: storeClient,
+		TemplateDir: filepath.Join(dir, "templates"),
+	}
+	if configure != nil {
+		configure(&c)
+	}
+	return Process(c)
+}
+
 var templateResourceConfigTmpl = `
 [template]
 src = "{{.src}}"
@@ -165,3 +205,616 @@ func TestSameConfigFalse(t *testing.T) {
 		t.Errorf("Expected sameConfig(src, dest) to be %v, got %v", false, status)
 	}
 }
+
+var layeredResourceConfig = `
+[template]
+src = "layered.tmpl"
+dest = "%s"
+prefixes = ["/hosts/web1", "/defaults"]
+keys = [
+  "/port",
+  "/workers",
+]
+`
+
+func TestProcessTemplateResourceWithPrefixes(t *testing.T) {
+	log.SetQuiet(true)
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+
+	srcTemplateFile := filepath.Join(tempConfDir, "templates", "layered.tmpl")
+	tmpl := `port={{getv "/port"}} from={{(getvFrom "/port").Prefix}} workers={{getv "/workers"}} from={{(getvFrom "/workers").Prefix}}`
+	if err := ioutil.WriteFile(srcTemplateFile, []byte(tmpl), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	destFile := filepath.Join(tempConfDir, "layered.conf")
+	templateResourcePath := filepath.Join(tempConfDir, "conf.d", "layered.toml")
+	resourceConfig := fmt.Sprintf(layeredResourceConfig, destFile)
+	if err := ioutil.WriteFile(templateResourcePath, []byte(resourceConfig), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	os.Setenv("HOSTS_WEB1_PORT", "8080")
+	os.Setenv("DEFAULTS_PORT", "80")
+	os.Setenv("DEFAULTS_WORKERS", "4")
+	defer os.Unsetenv("HOSTS_WEB1_PORT")
+	defer os.Unsetenv("DEFAULTS_PORT")
+	defer os.Unsetenv("DEFAULTS_WORKERS")
+	storeClient, err := env.NewEnvClient()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	c := Config{
+		ConfDir:     tempConfDir,
+		ConfigDir:   filepath.Join(tempConfDir, "conf.d"),
+		StoreClient: storeClient,
+		TemplateDir: filepath.Join(tempConfDir, "templates"),
+	}
+	if err := Process(c); err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := "port=8080 from=/hosts/web1 workers=4 from=/defaults"
+	results, err := ioutil.ReadFile(destFile)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(results) != expected {
+		t.Errorf("Expected contents of dest == '%s', got %s", expected, string(results))
+	}
+}
+
+var interpolatedResourceConfig = `
+[template]
+src = "app.tmpl"
+dest = "/etc/app/{{hostname}}.conf"
+prefix = "/clusters/{{getenv \"CONFD_TEST_CLUSTER\"}}"
+keys = [
+  "/hosts/{{hostname}}",
+  "/common",
+]
+`
+
+func TestNewTemplateResourceInterpolatesSettings(t *testing.T) {
+	log.SetQuiet(true)
+	f, err := ioutil.TempFile("", "resource")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(interpolatedResourceConfig); err != nil {
+		t.Fatal(err.Error())
+	}
+	f.Close()
+
+	os.Setenv("CONFD_TEST_CLUSTER", "east")
+	defer os.Unsetenv("CONFD_TEST_CLUSTER")
+	storeClient, err := env.NewEnvClient()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	c := Config{
+		HostFacts:   &HostFacts{Hostname: "web1"},
+		Prefix:      "/production",
+		StoreClient: storeClient,
+	}
+	tr, err := NewTemplateResource(f.Name(), c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if want := []string{"/production/clusters/east"}; !reflect.DeepEqual(tr.prefixes, want) {
+		t.Errorf("prefixes = %v, want %v", tr.prefixes, want)
+	}
+	if want := []string{"/hosts/web1", "/common"}; !reflect.DeepEqual(tr.Keys, want) {
+		t.Errorf("Keys = %v, want %v", tr.Keys, want)
+	}
+	if want := "/etc/app/web1.conf"; tr.Dest != want {
+		t.Errorf("Dest = %s, want %s", tr.Dest, want)
+	}
+}
+
+var encryptedResourceConfig = `
+[template]
+src = "secret.tmpl"
+dest = "%s"
+keys = [
+  "/password",
+]
+
+[template.vars]
+token = "enc:ZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXp7FK+DO/2C5NYO5PvZopVXfWrM971fxPw="
+`
+
+func TestProcessTemplateResourceDecryptsValues(t *testing.T) {
+	log.SetQuiet(true)
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+
+	srcTemplateFile := filepath.Join(tempConfDir, "templates", "secret.tmpl")
+	tmpl := `password={{getv "/password"}} token={{decrypt .token}}`
+	if err := ioutil.WriteFile(srcTemplateFile, []byte(tmpl), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	destFile := filepath.Join(tempConfDir, "secret.conf")
+	templateResourcePath := filepath.Join(tempConfDir, "conf.d", "secret.toml")
+	resourceConfig := fmt.Sprintf(encryptedResourceConfig, destFile)
+	if err := ioutil.WriteFile(templateResourcePath, []byte(resourceConfig), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	os.Setenv("PASSWORD", testCiphertext)
+	defer os.Unsetenv("PASSWORD")
+	storeClient, err := env.NewEnvClient()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	keyring := &Keyring{}
+	if err := keyring.AddKey(testKey); err != nil {
+		t.Fatal(err.Error())
+	}
+	c := Config{
+		ConfDir:     tempConfDir,
+		ConfigDir:   filepath.Join(tempConfDir, "conf.d"),
+		Keyring:     keyring,
+		StoreClient: storeClient,
+		TemplateDir: filepath.Join(tempConfDir, "templates"),
+	}
+	if err := Process(c); err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := "password=s3cr3t token=hunter2"
+	results, err := ioutil.ReadFile(destFile)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(results) != expected {
+		t.Errorf("Expected contents of dest == '%s', got %s", expected, string(results))
+	}
+
+	// With the wrong key nothing must be written.
+	os.Remove(destFile)
+	c.Keyring = &Keyring{}
+	if err := c.Keyring.AddKey(testOtherKey); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := Process(c); err == nil {
+		t.Errorf("Expected Process to fail when values cannot be decrypted")
+	}
+	if _, err := os.Stat(destFile); !os.IsNotExist(err) {
+		t.Errorf("Expected dest not to be written when values cannot be decrypted")
+	}
+}
+
+var rollbackResourceConfig = `
+[template]
+src = "app.tmpl"
+dest = "%s"
+keys = [
+  "/",
+]
+reload_cmd = "%s"
+`
+
+// processWithReload renders "new" over a dest holding "old" with reloadCmd, in
+// which %s is replaced by the dest path, and returns the final contents of
+// dest and the error of Process.
+func processWithReload(t *testing.T, reloadCmd string) (string, error) {
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+	destDir := filepath.Join(tempConfDir, "dest")
+	if err := os.Mkdir(destDir, 0755); err != nil {
+		t.Fatal(err.Error())
+	}
+	destFile := filepath.Join(destDir, "app.conf")
+	if err := ioutil.WriteFile(destFile, []byte("old"), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	resourceConfig := fmt.Sprintf(rollbackResourceConfig, destFile, fmt.Sprintf(reloadCmd, destFile))
+	processErr := processTestResources(t, tempConfDir, "new", nil, resourceConfig)
+	results, err := ioutil.ReadFile(destFile)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	files, err := ioutil.ReadDir(destDir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(files) != 1 {
+		t.Errorf("Expected only %s to be left in %s, got %d files", destFile, destDir, len(files))
+	}
+	return string(results), processErr
+}
+
+func TestSyncRollsBackWhenReloadFails(t *testing.T) {
+	log.SetQuiet(true)
+	// The reload only succeeds on the old config.
+	contents, err := processWithReload(t, "grep -q old %s")
+	if err == nil {
+		t.Errorf("Expected Process to fail when the reload fails")
+	}
+	if contents != "old" {
+		t.Errorf("Expected the previous dest to be restored, got %s", contents)
+	}
+}
+
+func TestSyncRollbackReloadFails(t *testing.T) {
+	log.SetQuiet(true)
+	contents, err := processWithReload(t, "test ! -f %s")
+	if err == nil || !strings.Contains(err.Error(), "reload of the restored") {
+		t.Errorf("Expected Process to report the failed reload of the restored dest, got %v", err)
+	}
+	if contents != "old" {
+		t.Errorf("Expected the previous dest to be restored, got %s", contents)
+	}
+}
+
+func TestSyncKeepsNewConfigWhenReloadSucceeds(t *testing.T) {
+	log.SetQuiet(true)
+	contents, err := processWithReload(t, "grep -q new %s")
+	if err != nil {
+		t.Errorf("Expected Process to succeed, got %s", err.Error())
+	}
+	if contents != "new" {
+		t.Errorf("Expected the new dest, got %s", contents)
+	}
+}
+
+var noopResourceConfig = `
+[template]
+src = "app.tmpl"
+dest = "%s"
+mode = "0644"
+keys = [
+  "/",
+]
+`
+
+// processNoop renders "new" over a dest holding "old" with mode 0600 in noop
+// mode and the given noop format, and returns what was written about the
+// pending change and the error of Process.
+func processNoop(t *testing.T, format string) (string, error) {
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+	destFile := filepath.Join(tempConfDir, "app.conf")
+	if err := ioutil.WriteFile(destFile, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	var out bytes.Buffer
+	noopOutput = &out
+	defer func() { noopOutput = os.Stdout }()
+	resourceConfig := fmt.Sprintf(noopResourceConfig, destFile)
+	processErr := processTestResources(t, tempConfDir, "new\n", func(c *Config) {
+		c.Noop = true
+		c.NoopFormat = format
+	}, resourceConfig)
+	contents, err := ioutil.ReadFile(destFile)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(contents) != "old\n" {
+		t.Errorf("Expected dest not to be modified in noop mode, got %s", string(contents))
+	}
+	return strings.Replace(out.String(), destFile, "app.conf", -1), processErr
+}
+
+func TestProcessNoopShowsDiff(t *testing.T) {
+	log.SetQuiet(true)
+	out, err := processNoop(t, "diff")
+	if err != ErrPendingChanges {
+		t.Errorf("Expected ErrPendingChanges, got %v", err)
+	}
+	expected := `app.conf mode would change from 0600 to 0644
+--- app.conf
++++ app.conf (rendered)
+@@ -1 +1 @@
+-old
++new
+`
+	if out != expected {
+		t.Errorf("Expected noop output\n%s\ngot\n%s", expected, out)
+	}
+}
+
+func TestProcessNoopShowsJSON(t *testing.T) {
+	log.SetQuiet(true)
+	out, err := processNoop(t, "json")
+	if err != ErrPendingChanges {
+		t.Errorf("Expected ErrPendingChanges, got %v", err)
+	}
+	var change pendingChange
+	if err := json.Unmarshal([]byte(out), &change); err != nil {
+		t.Fatalf("Expected a JSON object, got %s: %s", out, err.Error())
+	}
+	expected := pendingChange{
+		Resource: "app",
+		Dest:     "app.conf",
+		Mode:     &attrChange{"0600", "0644"},
+		Diff:     "--- app.conf\n+++ app.conf (rendered)\n@@ -1 +1 @@\n-old\n+new\n",
+	}
+	if !reflect.DeepEqual(change, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, change)
+	}
+}
+
+var noopSecretResourceConfig = `
+[template]
+src = "app.tmpl"
+dest = "%s"
+keys = [
+  "/password",
+]
+
+[template.vars]
+token = "enc:ZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXp7FK+DO/2C5NYO5PvZopVXfWrM971fxPw="
+`
+
+func TestProcessNoopHidesDecryptedValues(t *testing.T) {
+	log.SetQuiet(true)
+	os.Setenv("PASSWORD", testCiphertext)
+	defer os.Unsetenv("PASSWORD")
+	keyring := &Keyring{}
+	if err := keyring.AddKey(testKey); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() { noopOutput = os.Stdout }()
+	for _, tmpl := range []string{`password={{getv "/password"}}`, `token={{decrypt .token}}`} {
+		tempConfDir, err := createTempDirs()
+		if err != nil {
+			t.Fatalf("Failed to create temp dirs: %s", err.Error())
+		}
+		destFile := filepath.Join(tempConfDir, "app.conf")
+		if err := ioutil.WriteFile(destFile, []byte("old"), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+		var out bytes.Buffer
+		noopOutput = &out
+		resourceConfig := fmt.Sprintf(noopSecretResourceConfig, destFile)
+		err = processTestResources(t, tempConfDir, tmpl, func(c *Config) {
+			c.Keyring = keyring
+			c.Noop = true
+		}, resourceConfig)
+		os.RemoveAll(tempConfDir)
+		if err != ErrPendingChanges {
+			t.Errorf("Expected ErrPendingChanges for %s, got %v", tmpl, err)
+		}
+		expected := "app.conf contents would change, the diff is hidden as the template uses decrypted values\n"
+		if actual := strings.Replace(out.String(), destFile, "app.conf", -1); actual != expected {
+			t.Errorf("Expected noop output for %s\n%s\ngot\n%s", tmpl, expected, actual)
+		}
+	}
+}
+
+var argvResourceConfig = `
+[template]
+src = "app.tmpl"
+dest = "%s"
+keys = [
+  "/",
+]
+check_cmd = ["grep", "-q", "new", "{{.src}}"]
+reload_cmd = ["touch", "%s"]
+`
+
+func TestProcessArgvCommands(t *testing.T) {
+	log.SetQuiet(true)
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+	// The stage file is created in the dest directory, so its path would be
+	// interpreted by a shell.
+	destDir := filepath.Join(tempConfDir, "conf; touch pwned; echo ")
+	if err := os.Mkdir(destDir, 0755); err != nil {
+		t.Fatal(err.Error())
+	}
+	destFile := filepath.Join(destDir, "app.conf")
+	reloaded := filepath.Join(tempConfDir, "reloaded")
+	resourceConfig := fmt.Sprintf(argvResourceConfig, destFile, reloaded)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := os.Chdir(tempConfDir); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Chdir(wd)
+	if err := processTestResources(t, tempConfDir, "new", nil, resourceConfig); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !isFileExist(destFile) {
+		t.Errorf("Expected %s to be written", destFile)
+	}
+	if !isFileExist(reloaded) {
+		t.Errorf("Expected the reload command to run")
+	}
+	if isFileExist(filepath.Join(tempConfDir, "pwned")) {
+		t.Errorf("Expected the stage file path not to be interpreted by a shell")
+	}
+}
+
+var hooksResourceConfig = `
+[template]
+src = "app.tmpl"
+dest = "%s"
+keys = [
+  "/",
+]
+check_cmd = "%s"
+reload_cmd = "%s"
+pre_sync_cmd = "echo pre $CONFD_RESOURCE $(basename $CONFD_DEST) $(cat $CONFD_STAGED) >> %[4]s"
+post_sync_cmd = "echo post $(cat $CONFD_DEST) staged=$CONFD_STAGED >> %[4]s"
+on_error_cmd = ["/bin/sh", "-c", "echo error $CONFD_ERROR >> %[4]s"]
+`
+
+// processWithHooks renders "new" over a dest holding "old" with the lifecycle
+// hooks logging to a file, the given check command and reloadCmd, in which %s
+// is replaced by the dest path, and returns the hook log, with the dest path
+// replaced by app.conf, and the error of Process.
+func processWithHooks(t *testing.T, checkCmd, reloadCmd string) (string, error) {
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+	destFile := filepath.Join(tempConfDir, "app.conf")
+	if err := ioutil.WriteFile(destFile, []byte("old"), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	hookLog := filepath.Join(tempConfDir, "hooks.log")
+	resourceConfig := fmt.Sprintf(hooksResourceConfig, destFile, checkCmd, strings.Replace(reloadCmd, "%s", destFile, -1), hookLog)
+	processErr := processTestResources(t, tempConfDir, "new", nil, resourceConfig)
+	contents, err := ioutil.ReadFile(hookLog)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return strings.Replace(string(contents), destFile, "app.conf", -1), processErr
+}
+
+func TestProcessRunsSyncHooks(t *testing.T) {
+	log.SetQuiet(true)
+	hookLog, err := processWithHooks(t, "true", "true")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// The staged file is gone once the dest is replaced.
+	expected := "pre app app.conf new\npost new staged=\n"
+	if hookLog != expected {
+		t.Errorf("Expected hook log %q, got %q", expected, hookLog)
+	}
+}
+
+func TestProcessRunsErrorHook(t *testing.T) {
+	log.SetQuiet(true)
+	hookLog, err := processWithHooks(t, "echo invalid config; exit 1", "true")
+	if err == nil {
+		t.Fatal("Expected Process to fail")
+	}
+	expected := "error Config check failed: exit status 1 - invalid config\n"
+	if hookLog != expected {
+		t.Errorf("Expected hook log %q, got %q", expected, hookLog)
+	}
+}
+
+func TestProcessRunsPostSyncHookAfterRollback(t *testing.T) {
+	log.SetQuiet(true)
+	// The reload only succeeds on the old config.
+	hookLog, err := processWithHooks(t, "true", "grep -q old %s")
+	if err == nil {
+		t.Fatal("Expected Process to fail when the reload fails")
+	}
+	expected := "pre app app.conf new\npost old staged=\nerror Reload failed, previous app.conf restored: exit status 1\n"
+	if hookLog != expected {
+		t.Errorf("Expected hook log %q, got %q", expected, hookLog)
+	}
+}
+
+var symlinkResourceConfig = `
+[template]
+src = "app.tmpl"
+dest = "%s"
+keys = [
+  "/",
+]
+follow_symlinks = %t
+`
+
+// processSymlinkedDest renders "new" to a dest that links to a file holding
+// "old" in another directory, and returns the temporary directory holding
+// both.
+func processSymlinkedDest(t *testing.T, followSymlinks bool) string {
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	if err := os.Mkdir(filepath.Join(tempConfDir, "shared"), 0755); err != nil {
+		t.Fatal(err.Error())
+	}
+	targetFile := filepath.Join(tempConfDir, "shared", "app.conf")
+	if err := ioutil.WriteFile(targetFile, []byte("old"), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	destFile := filepath.Join(tempConfDir, "app.conf")
+	if err := os.Symlink("shared/app.conf", destFile); err != nil {
+		t.Fatal(err.Error())
+	}
+	resourceConfig := fmt.Sprintf(symlinkResourceConfig, destFile, followSymlinks)
+	if err := processTestResources(t, tempConfDir, "new", nil, resourceConfig); err != nil {
+		t.Fatal(err.Error())
+	}
+	return tempConfDir
+}
+
+func TestProcessFollowsSymlinkedDest(t *testing.T) {
+	log.SetQuiet(true)
+	tempConfDir := processSymlinkedDest(t, true)
+	defer os.RemoveAll(tempConfDir)
+	fi, err := os.Lstat(filepath.Join(tempConfDir, "app.conf"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Expected dest to still be a symbolic link")
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(tempConfDir, "shared", "app.conf"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(contents) != "new" {
+		t.Errorf("Expected the link target to hold %q, got %q", "new", string(contents))
+	}
+}
+
+func TestProcessReplacesSymlinkedDest(t *testing.T) {
+	log.SetQuiet(true)
+	tempConfDir := processSymlinkedDest(t, false)
+	defer os.RemoveAll(tempConfDir)
+	fi, err := os.Lstat(filepath.Join(tempConfDir, "app.conf"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		t.Errorf("Expected dest to be replaced by a regular file")
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(tempConfDir, "shared", "app.conf"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(contents) != "old" {
+		t.Errorf("Expected the link target to be unchanged, got %q", string(contents))
+	}
+}
+
+func TestParseMountedDest(t *testing.T) {
+	tests := []struct {
+		value     string
+		overwrite bool
+	}{
+		{"", true},
+		{"overwrite", true},
+		{"fail", false},
+	}
+	for _, tt := range tests {
+		overwrite, err := parseMountedDest(tt.value)
+		if err != nil {
+			t.Fatalf("parseMountedDest(%q) failed: %s", tt.value, err.Error())
+		}
+		if overwrite != tt.overwrite {
+			t.Errorf("parseMountedDest(%q) = %t, want %t", tt.value, overwrite, tt.overwrite)
+		}
+	}
+	if _, err := parseMountedDest("copy"); err == nil {
+		t.Error("Expected an invalid mounted_dest to fail")
+	}
+}
@@ -0,0 +1,76 @@
+package template
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/wuranbo/confd/log"
+)
+
+// destBackup is a copy of a target config taken before it is overwritten, so
+// the previous config can be restored if the new one fails to reload.
+type destBackup struct {
+	dest string
+	path string // path of the copy, empty if dest did not exist
+	mode os.FileMode
+	uid  int
+	gid  int
+}
+
+// backupDest copies dest to a hidden file in its directory, keeping its mode,
+// owner and group.
+// It returns an error if any.
+func backupDest(dest string) (*destBackup, error) {
+	b := &destBackup{dest: dest}
+	src, err := os.Open(dest)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	stats, err := src.Stat()
+	if err != nil {
+		return nil, err
+	}
+	b.mode = stats.Mode()
+	b.uid = int(stats.Sys().(*syscall.Stat_t).Uid)
+	b.gid = int(stats.Sys().(*syscall.Stat_t).Gid)
+	temp, err := ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest)+".rollback")
+	if err != nil {
+		return nil, err
+	}
+	defer temp.Close()
+	if _, err := io.Copy(temp, src); err != nil {
+		os.Remove(temp.Name())
+		return nil, err
+	}
+	os.Chmod(temp.Name(), b.mode)
+	os.Chown(temp.Name(), b.uid, b.gid)
+	b.path = temp.Name()
+	log.Debug("Saved " + dest + " to " + b.path)
+	return b, nil
+}
+
+// restore puts the saved config back in place of dest, or removes dest if it
+// did not exist when the backup was taken.
+// It returns an error if any.
+func (b *destBackup) restore() error {
+	if b.path == "" {
+		return os.Remove(b.dest)
+	}
+	// dest was replaced with the new config, so it can be overwritten again
+	// even if it is a mount point.
+	return replaceFile(b.path, b.dest, b.mode, b.uid, b.gid, true)
+}
+
+// remove deletes the saved copy, if it is still there.
+func (b *destBackup) remove() {
+	if b.path != "" {
+		os.Remove(b.path)
+	}
+}
@@ -0,0 +1,185 @@
+package template
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/kelseyhightower/memkv"
+)
+
+// storeFuncs wraps the lookups of a memkv.Store with the default value and
+// strict mode semantics of a template resource.
+type storeFuncs struct {
+	store  *memkv.Store
+	layers *memkv.Store
+	strict bool
+}
+
+func NewStoreFuncMap(store, layers *memkv.Store, strict bool) map[string]interface{} {
+	return newStoreFuncMap(store, layers, strict)
+}
+
+// newStoreFuncMap returns the template functions backed by store, with layers
+// holding the prefix each key was found under. When strict is set, lookups
+// that find nothing return an error instead of an empty result.
+func newStoreFuncMap(store, layers *memkv.Store, strict bool) map[string]interface{} {
+	s := &storeFuncs{store, layers, strict}
+	m := make(map[string]interface{})
+	addFuncs(m, store.FuncMap)
+	m["getv"] = s.getValue
+	m["getvFrom"] = s.getLayer
+	m["getint"] = s.getInt
+	m["getbool"] = s.getBool
+	m["getduration"] = s.getDuration
+	m["ls"] = s.list
+	m["lsdir"] = s.listDir
+	m["tree"] = s.tree
+	return m
+}
+
+// getValue returns the value of key. If key does not exist the optional
+// default is returned, otherwise an error.
+func (s *storeFuncs) getValue(key string, v ...string) (string, error) {
+	value, ok, err := s.lookup(key, len(v) > 0)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return v[0], nil
+	}
+	return value, nil
+}
+
+// getLayer returns the value of key along with the prefix it was found
+// under.
+func (s *storeFuncs) getLayer(key string) (Layer, error) {
+	value, _, err := s.lookup(key, false)
+	if err != nil {
+		return Layer{}, err
+	}
+	prefix, _ := s.layers.GetValue(key)
+	return Layer{key, value, prefix}, nil
+}
+
+// lookup returns the value of key and whether it was found. A missing key
+// with no default is an error.
+func (s *storeFuncs) lookup(key string, hasDefault bool) (string, bool, error) {
+	value, err := s.store.GetValue(key)
+	if err == nil {
+		return value, true, nil
+	}
+	if err == memkv.ErrNotExist && hasDefault {
+		return "", false, nil
+	}
+	return "", false, fmt.Errorf("%s: %s", key, err.Error())
+}
+
+// getInt returns the value of key parsed as an integer.
+func (s *storeFuncs) getInt(key string, v ...int) (int, error) {
+	value, ok, err := s.lookup(key, len(v) > 0)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return v[0], nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid integer value %q", key, value)
+	}
+	return i, nil
+}
+
+// getBool returns the value of key parsed as a boolean.
+func (s *storeFuncs) getBool(key string, v ...bool) (bool, error) {
+	value, ok, err := s.lookup(key, len(v) > 0)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return v[0], nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s: invalid boolean value %q", key, value)
+	}
+	return b, nil
+}
+
+// getDuration returns the value of key parsed with time.ParseDuration. The
+// optional default is parsed the same way.
+func (s *storeFuncs) getDuration(key string, v ...string) (time.Duration, error) {
+	value, ok, err := s.lookup(key, len(v) > 0)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		value = v[0]
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid duration value %q", key, value)
+	}
+	return d, nil
+}
+
+func (s *storeFuncs) list(filePath string) ([]string, error) {
+	vs := s.store.List(filePath)
+	if s.strict && len(vs) == 0 {
+		return nil, fmt.Errorf("%s: %s", filePath, memkv.ErrNoMatch.Error())
+	}
+	return vs, nil
+}
+
+func (s *storeFuncs) listDir(filePath string) ([]string, error) {
+	vs := s.store.ListDir(filePath)
+	if s.strict && len(vs) == 0 {
+		return nil, fmt.Errorf("%s: %s", filePath, memkv.ErrNoMatch.Error())
+	}
+	return vs, nil
+}
+
+// tree returns everything under prefix as nested maps keyed by path segment,
+// with the values of the keys as leaves. A key that has both a value and
+// subkeys keeps its value under the empty string.
+func (s *storeFuncs) tree(prefix string) (map[string]interface{}, error) {
+	dir := path.Join("/", prefix)
+	t := s.subtree(dir)
+	if value, err := s.store.GetValue(dir); err == nil && len(t) > 0 {
+		t[""] = value
+	}
+	if s.strict && len(t) == 0 {
+		return nil, fmt.Errorf("%s: %s", prefix, memkv.ErrNoMatch.Error())
+	}
+	return t, nil
+}
+
+func (s *storeFuncs) subtree(dir string) map[string]interface{} {
+	m := make(map[string]interface{})
+	// List matches on a plain string prefix, so end dir with a slash to
+	// keep /services from matching /services2.
+	p := dir
+	if p != "/" {
+		p += "/"
+	}
+	dirs := make(map[string]bool)
+	for _, d := range s.store.ListDir(p) {
+		dirs[d] = true
+	}
+	for _, name := range s.store.List(p) {
+		key := path.Join(dir, name)
+		value, err := s.store.GetValue(key)
+		if !dirs[name] {
+			m[name] = value
+			continue
+		}
+		sub := s.subtree(key)
+		if err == nil {
+			sub[""] = value
+		}
+		m[name] = sub
+	}
+	return m
+}
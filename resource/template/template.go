@@ -0,0 +1,233 @@
+package template
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// maxIncludeDepth limits how deeply include calls may nest, so that a partial
+// including itself fails the render instead of exhausting the stack.
+const maxIncludeDepth = 100
+
+// TemplateOptions holds the settings used to parse and execute a source
+// template.
+type TemplateOptions struct {
+	LeftDelim  string
+	RightDelim string
+	Strict     bool
+	Partials   map[string]string // partial name to template text
+}
+
+func NewTemplate(name, text string, funcMap map[string]interface{}, opts TemplateOptions) (*template.Template, error) {
+	return newTemplate(name, text, funcMap, opts)
+}
+
+// newTemplate returns the template named name parsed from text using funcMap.
+// Only the partials in opts it refers to are parsed: those named by its
+// template actions, and the partials they refer to in turn, are defined
+// before it returns, and those rendered with include when they are first
+// included. A partial no template uses can therefore hold text that does not
+// parse with the delimiters of every template. The include function is bound
+// to the returned template, and the regex functions get a pattern cache of
+// their own.
+// Empty delimiters default to "{{" and "}}".
+// It returns an error if text or a partial it refers to cannot be parsed.
+func newTemplate(name, text string, funcMap map[string]interface{}, opts TemplateOptions) (*template.Template, error) {
+	tmpl := template.New(name)
+	depth := 0
+	include := func(name string, data interface{}) (string, error) {
+		if depth >= maxIncludeDepth {
+			return "", errors.New("include " + name + ": maximum include depth exceeded")
+		}
+		depth++
+		defer func() { depth-- }()
+		if err := parsePartial(tmpl, name, opts.Partials); err != nil {
+			return "", err
+		}
+		var b bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&b, name, data); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+	tmpl.Funcs(funcMap).Funcs(newRegexFuncMap()).Funcs(map[string]interface{}{"include": include})
+	tmpl.Delims(opts.LeftDelim, opts.RightDelim)
+	if opts.Strict {
+		tmpl.Option("missingkey=error")
+	}
+	if _, err := tmpl.Parse(text); err != nil {
+		return nil, err
+	}
+	for _, t := range tmpl.Templates() {
+		if err := parseReferencedPartials(tmpl, t.Tree.Root, opts.Partials); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl, nil
+}
+
+// parsePartial defines the partial named name in tmpl, along with the
+// partials it refers to, unless a template with that name is defined already.
+// Unknown names are left for the execution of the template to report.
+func parsePartial(tmpl *template.Template, name string, partials map[string]string) error {
+	if tmpl.Lookup(name) != nil {
+		return nil
+	}
+	text, ok := partials[name]
+	if !ok {
+		return nil
+	}
+	p, err := tmpl.New(name).Parse(text)
+	if err != nil {
+		return fmt.Errorf("partial %s: %s", name, err.Error())
+	}
+	return parseReferencedPartials(tmpl, p.Tree.Root, partials)
+}
+
+// parseReferencedPartials defines in tmpl the partials named by the template
+// actions below node.
+func parseReferencedPartials(tmpl *template.Template, node parse.Node, partials map[string]string) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := parseReferencedPartials(tmpl, child, partials); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return parseReferencedBranches(tmpl, &n.BranchNode, partials)
+	case *parse.RangeNode:
+		return parseReferencedBranches(tmpl, &n.BranchNode, partials)
+	case *parse.WithNode:
+		return parseReferencedBranches(tmpl, &n.BranchNode, partials)
+	case *parse.TemplateNode:
+		return parsePartial(tmpl, n.Name, partials)
+	}
+	return nil
+}
+
+func parseReferencedBranches(tmpl *template.Template, n *parse.BranchNode, partials map[string]string) error {
+	if err := parseReferencedPartials(tmpl, n.List, partials); err != nil {
+		return err
+	}
+	return parseReferencedPartials(tmpl, n.ElseList, partials)
+}
+
+// loadPartials reads every file below dir and returns their contents keyed by
+// the path relative to dir, e.g. "tls.tmpl" or "nginx/log.tmpl". A missing or
+// unset dir yields no partials.
+func loadPartials(dir string) (map[string]string, error) {
+	if dir == "" || !isFileExist(dir) {
+		return nil, nil
+	}
+	paths, err := recursiveFindFiles(dir, "*")
+	if err != nil {
+		return nil, err
+	}
+	partials := make(map[string]string)
+	for _, p := range paths {
+		name, err := filepath.Rel(dir, p)
+		if err != nil {
+			return nil, err
+		}
+		text, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		partials[filepath.ToSlash(name)] = string(text)
+	}
+	return partials, nil
+}
+
+func InterpolateVars(vars map[string]interface{}, facts *HostFacts) (map[string]interface{}, error) {
+	return interpolateVars(vars, facts)
+}
+
+// interpolateVars returns a copy of vars in which every string, including the
+// ones nested in arrays and tables, has been interpolated.
+func interpolateVars(vars map[string]interface{}, facts *HostFacts) (map[string]interface{}, error) {
+	if vars == nil {
+		return nil, nil
+	}
+	v, err := interpolateValue(vars, facts)
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]interface{}), nil
+}
+
+func interpolateValue(v interface{}, facts *HostFacts) (interface{}, error) {
+	switch value := v.(type) {
+	case string:
+		return interpolate(value, facts)
+	case []interface{}:
+		out := make([]interface{}, len(value))
+		for i, item := range value {
+			iv, err := interpolateValue(item, facts)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = iv
+		}
+		return out, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			iv, err := interpolateValue(item, facts)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", k, err.Error())
+			}
+			out[k] = iv
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+func Interpolate(s string, facts *HostFacts) (string, error) {
+	return interpolate(s, facts)
+}
+
+func InterpolateAll(ss []string, facts *HostFacts) error {
+	return interpolateAll(ss, facts)
+}
+
+// interpolateAll interpolates every string of ss in place.
+func interpolateAll(ss []string, facts *HostFacts) error {
+	for i, s := range ss {
+		v, err := interpolate(s, facts)
+		if err != nil {
+			return err
+		}
+		ss[i] = v
+	}
+	return nil
+}
+
+// interpolate evaluates s as a template that may use getenv, the host fact
+// functions and the other functions that do not depend on the store.
+func interpolate(s string, facts *HostFacts) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	funcMap := newFuncMap()
+	addFuncs(funcMap, facts.FuncMap())
+	tmpl, err := template.New("interpolate").Funcs(funcMap).Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, nil); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -24,13 +24,40 @@ func newFuncMap() map[string]interface{} {
 	m["dir"] = path.Dir
 	m["getenv"] = os.Getenv
 	m["join"] = strings.Join
-	m["datetime"] = time.Now
 	m["concat"] = Concat
 	m["byteToM"] = ByteToM
 	m["strsub"] = StringSub
 	m["stradd"] = StringAdd
 	m["strmul"] = StringMul
 	m["strdiv"] = StringDiv
+	m["base64Encode"] = Base64Encode
+	m["base64Decode"] = Base64Decode
+	m["sha256"] = Sha256
+	m["urlEncode"] = UrlEncode
+	m["shellQuote"] = ShellQuote
+	m["jsonEscape"] = JsonEscape
+	m["xmlEscape"] = XmlEscape
+	m["iniEscape"] = IniEscape
+	m["parseCIDR"] = ParseCIDR
+	m["cidrContains"] = CIDRContains
+	m["cidrNetwork"] = CIDRNetwork
+	m["cidrNetmask"] = CIDRNetmask
+	m["cidrBroadcast"] = CIDRBroadcast
+	m["cidrHost"] = CIDRHost
+	m["cidrHosts"] = CIDRHosts
+	m["sortIPs"] = SortIPs
+	m["dict"] = Dict
+	m["list"] = List
+	m["append"] = Append
+	m["keys"] = Keys
+	m["values"] = Values
+	m["uniq"] = Uniq
+	m["sortBy"] = SortBy
+	m["filter"] = Filter
+	m["first"] = First
+	m["last"] = Last
+	m["sublist"] = Sublist
+	addFuncs(m, newTimeFuncMap(time.Now))
 	return m
 }
 
@@ -86,6 +113,8 @@ func toInt(data interface{}) (ret int64, err error) {
 		ret = int64(v)
 	case int32:
 		ret = int64(v)
+	case int64:
+		ret = v
 	case uint:
 		ret = int64(v)
 	case uint8:
@@ -121,7 +150,7 @@ func toInt(data interface{}) (ret int64, err error) {
 			}
 		}
 	default:
-		ret = 0
+		err = fmt.Errorf("cannot convert %T to an integer", data)
 	}
 	return ret, err
 }
@@ -0,0 +1,317 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kelseyhightower/memkv"
+)
+
+// Dict returns a map built from alternating key and value arguments.
+func Dict(kvs ...interface{}) (map[string]interface{}, error) {
+	if len(kvs)%2 != 0 {
+		return nil, errors.New("dict requires an even number of arguments")
+	}
+	m := make(map[string]interface{}, len(kvs)/2)
+	for i := 0; i < len(kvs); i += 2 {
+		k, ok := kvs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict key %v is not a string", kvs[i])
+		}
+		m[k] = kvs[i+1]
+	}
+	return m, nil
+}
+
+func List(items ...interface{}) []interface{} {
+	return items
+}
+
+// Append returns list with items added to its end. The result has the type of
+// list when all items fit in it, e.g. strings appended to a []string, and is
+// a []interface{} otherwise.
+func Append(list interface{}, items ...interface{}) (interface{}, error) {
+	v, err := sliceValue(list)
+	if err != nil {
+		return nil, err
+	}
+	elem := v.Type().Elem()
+	for _, item := range items {
+		if item == nil || !reflect.TypeOf(item).AssignableTo(elem) {
+			elem = reflect.TypeOf((*interface{})(nil)).Elem()
+			break
+		}
+	}
+	out := reflect.MakeSlice(reflect.SliceOf(elem), 0, v.Len()+len(items))
+	for i := 0; i < v.Len(); i++ {
+		out = reflect.Append(out, v.Index(i))
+	}
+	for _, item := range items {
+		iv := reflect.ValueOf(item)
+		if item == nil {
+			iv = reflect.Zero(elem)
+		}
+		out = reflect.Append(out, iv)
+	}
+	return out.Interface(), nil
+}
+
+// Keys returns the sorted keys of a map with string keys, or the keys of
+// KVPairs in order.
+func Keys(in interface{}) ([]string, error) {
+	if kvs, ok := in.(memkv.KVPairs); ok {
+		ks := make([]string, len(kvs))
+		for i, kv := range kvs {
+			ks[i] = kv.Key
+		}
+		return ks, nil
+	}
+	v := reflect.ValueOf(in)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return nil, fmt.Errorf("keys of %T: not a map with string keys", in)
+	}
+	ks := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		ks = append(ks, k.String())
+	}
+	sort.Strings(ks)
+	return ks, nil
+}
+
+// Values returns the values of a map with string keys in key order, or the
+// values of KVPairs in order.
+func Values(in interface{}) ([]interface{}, error) {
+	if kvs, ok := in.(memkv.KVPairs); ok {
+		vs := make([]interface{}, len(kvs))
+		for i, kv := range kvs {
+			vs[i] = kv.Value
+		}
+		return vs, nil
+	}
+	ks, err := Keys(in)
+	if err != nil {
+		return nil, err
+	}
+	v := reflect.ValueOf(in)
+	vs := make([]interface{}, len(ks))
+	for i, k := range ks {
+		vs[i] = v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key())).Interface()
+	}
+	return vs, nil
+}
+
+// Uniq returns list without repeated elements, keeping the first occurrence
+// of each.
+func Uniq(list interface{}) (interface{}, error) {
+	v, err := sliceValue(list)
+	if err != nil {
+		return nil, err
+	}
+	out := reflect.MakeSlice(v.Type(), 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		dup := false
+		for j := 0; j < out.Len(); j++ {
+			if reflect.DeepEqual(v.Index(i).Interface(), out.Index(j).Interface()) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			out = reflect.Append(out, v.Index(i))
+		}
+	}
+	return out.Interface(), nil
+}
+
+// SortBy sorts KVPairs or a []string according to spec, a comma separated
+// list starting with the field to sort on, "key" or "value", followed by any
+// of the options "numeric" and "reverse". A []string is sorted by its values
+// whatever the field. Equal elements keep their order.
+func SortBy(spec string, list interface{}) (interface{}, error) {
+	field, opts, err := parseFieldSpec(spec, "numeric", "reverse")
+	if err != nil {
+		return nil, err
+	}
+	fields, err := fieldValues(list, field)
+	if err != nil {
+		return nil, err
+	}
+	nums := make([]float64, len(fields))
+	if opts["numeric"] {
+		for i, f := range fields {
+			if nums[i], err = strconv.ParseFloat(f, 64); err != nil {
+				return nil, fmt.Errorf("sortBy: %q is not a number", f)
+			}
+		}
+	}
+	less := func(i, j int) bool {
+		if opts["numeric"] {
+			return nums[i] < nums[j]
+		}
+		return fields[i] < fields[j]
+	}
+	order := make([]int, len(fields))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Stable(byOrder{order, func(a, b int) bool {
+		if opts["reverse"] {
+			return less(b, a)
+		}
+		return less(a, b)
+	}})
+	return reorder(list, order), nil
+}
+
+// Filter returns the elements of KVPairs or a []string whose field matches
+// pattern. spec is the field, "key" or "value", optionally followed by
+// ",regex" to match pattern as a regular expression instead of a glob.
+func Filter(spec, pattern string, list interface{}) (interface{}, error) {
+	field, opts, err := parseFieldSpec(spec, "regex")
+	if err != nil {
+		return nil, err
+	}
+	match := func(s string) (bool, error) {
+		return filepath.Match(pattern, s)
+	}
+	if opts["regex"] {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		match = func(s string) (bool, error) {
+			return re.MatchString(s), nil
+		}
+	}
+	fields, err := fieldValues(list, field)
+	if err != nil {
+		return nil, err
+	}
+	keep := make([]int, 0)
+	for i, f := range fields {
+		ok, err := match(f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			keep = append(keep, i)
+		}
+	}
+	return reorder(list, keep), nil
+}
+
+// First returns the first element of list, or nil if it is empty.
+func First(list interface{}) (interface{}, error) {
+	v, err := sliceValue(list)
+	if err != nil || v.Len() == 0 {
+		return nil, err
+	}
+	return v.Index(0).Interface(), nil
+}
+
+// Last returns the last element of list, or nil if it is empty.
+func Last(list interface{}) (interface{}, error) {
+	v, err := sliceValue(list)
+	if err != nil || v.Len() == 0 {
+		return nil, err
+	}
+	return v.Index(v.Len() - 1).Interface(), nil
+}
+
+// Sublist returns the elements of list from start up to, but not including,
+// the optional end. Indexes past the end of list are clamped to its length.
+func Sublist(list interface{}, start int, end ...int) (interface{}, error) {
+	v, err := sliceValue(list)
+	if err != nil {
+		return nil, err
+	}
+	e := v.Len()
+	if len(end) > 0 && end[0] < e {
+		e = end[0]
+	}
+	if start < 0 || e < 0 {
+		return nil, errors.New("sublist: negative index")
+	}
+	if start > e {
+		start = e
+	}
+	return v.Slice(start, e).Interface(), nil
+}
+
+func sliceValue(list interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice {
+		return v, fmt.Errorf("%T is not a list", list)
+	}
+	return v, nil
+}
+
+// parseFieldSpec splits a "field,option,..." spec, checking the field is key
+// or value and every option is one of allowed.
+func parseFieldSpec(spec string, allowed ...string) (string, map[string]bool, error) {
+	parts := strings.Split(spec, ",")
+	field := strings.TrimSpace(parts[0])
+	if field != "key" && field != "value" {
+		return "", nil, fmt.Errorf("Invalid field %q, must be key or value", field)
+	}
+	opts := make(map[string]bool)
+	for _, p := range parts[1:] {
+		opt := strings.TrimSpace(p)
+		valid := false
+		for _, a := range allowed {
+			if opt == a {
+				valid = true
+			}
+		}
+		if !valid {
+			return "", nil, fmt.Errorf("Invalid option %q in %q", opt, spec)
+		}
+		opts[opt] = true
+	}
+	return field, opts, nil
+}
+
+// fieldValues returns the named field of every element of KVPairs, or the
+// elements of a []string.
+func fieldValues(list interface{}, field string) ([]string, error) {
+	switch l := list.(type) {
+	case memkv.KVPairs:
+		fs := make([]string, len(l))
+		for i, kv := range l {
+			if field == "key" {
+				fs[i] = kv.Key
+			} else {
+				fs[i] = kv.Value
+			}
+		}
+		return fs, nil
+	case []string:
+		return l, nil
+	}
+	return nil, fmt.Errorf("%T is not a list of KVPairs or strings", list)
+}
+
+// reorder returns the elements of list at the given indexes, in that order.
+func reorder(list interface{}, indexes []int) interface{} {
+	v := reflect.ValueOf(list)
+	out := reflect.MakeSlice(v.Type(), 0, len(indexes))
+	for _, i := range indexes {
+		out = reflect.Append(out, v.Index(i))
+	}
+	return out.Interface()
+}
+
+type byOrder struct {
+	order []int
+	less  func(a, b int) bool
+}
+
+func (b byOrder) Len() int           { return len(b.order) }
+func (b byOrder) Less(i, j int) bool { return b.less(b.order[i], b.order[j]) }
+func (b byOrder) Swap(i, j int)      { b.order[i], b.order[j] = b.order[j], b.order[i] }
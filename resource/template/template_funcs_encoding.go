@@ -0,0 +1,72 @@
+package template
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+func Base64Encode(data string) string {
+	return base64.StdEncoding.EncodeToString([]byte(data))
+}
+
+func Base64Decode(data string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Sha256 returns the hex encoded SHA-256 digest of data.
+func Sha256(data string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
+}
+
+// UrlEncode escapes data so it can be placed inside a URL query.
+func UrlEncode(data string) string {
+	return url.QueryEscape(data)
+}
+
+// ShellQuote returns data as a single quoted POSIX shell word.
+func ShellQuote(data string) string {
+	return "'" + strings.Replace(data, "'", `'\''`, -1) + "'"
+}
+
+// JsonEscape escapes data for use inside a JSON string literal. The
+// surrounding quotes are not included.
+func JsonEscape(data string) (string, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(data); err != nil {
+		return "", err
+	}
+	s := strings.TrimSuffix(b.String(), "\n")
+	return s[1 : len(s)-1], nil
+}
+
+// XmlEscape escapes data for use in XML text or attribute values.
+func XmlEscape(data string) (string, error) {
+	var b bytes.Buffer
+	if err := xml.EscapeText(&b, []byte(data)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// IniEscape returns data unchanged if it is safe as an INI value. Otherwise
+// it returns data double quoted, with backslashes, quotes and newlines
+// escaped.
+func IniEscape(data string) string {
+	if !strings.ContainsAny(data, ";#=\"\\\r\n") && strings.TrimSpace(data) == data {
+		return data
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+	return `"` + r.Replace(data) + `"`
+}
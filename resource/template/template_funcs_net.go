@@ -0,0 +1,184 @@
+package template
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+)
+
+// maxCIDRHosts limits the number of addresses CIDRHosts will enumerate.
+const maxCIDRHosts = 65536
+
+// CIDR describes a parsed network in CIDR notation.
+type CIDR struct {
+	IP        string // the address as written, e.g. 10.0.0.5 in 10.0.0.5/24
+	Network   string
+	Netmask   string
+	Broadcast string // the last address of the network
+	PrefixLen int
+	ipnet     *net.IPNet
+}
+
+func ParseCIDR(s string) (*CIDR, error) {
+	ip, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	ones, _ := ipnet.Mask.Size()
+	return &CIDR{
+		IP:        ip.String(),
+		Network:   ipnet.IP.String(),
+		Netmask:   net.IP(ipnet.Mask).String(),
+		Broadcast: lastIP(ipnet).String(),
+		PrefixLen: ones,
+		ipnet:     ipnet,
+	}, nil
+}
+
+// CIDRContains reports whether the network cidr contains ip.
+func CIDRContains(cidr, ip string) (bool, error) {
+	c, err := ParseCIDR(cidr)
+	if err != nil {
+		return false, err
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false, errors.New("Invalid IP address " + ip)
+	}
+	return c.ipnet.Contains(addr), nil
+}
+
+func CIDRNetwork(cidr string) (string, error) {
+	c, err := ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	return c.Network, nil
+}
+
+func CIDRNetmask(cidr string) (string, error) {
+	c, err := ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	return c.Netmask, nil
+}
+
+func CIDRBroadcast(cidr string) (string, error) {
+	c, err := ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	return c.Broadcast, nil
+}
+
+// CIDRHost returns the address at offset n in the network cidr. A negative n
+// counts back from the last address, so -1 is the broadcast address.
+func CIDRHost(cidr string, n int) (string, error) {
+	c, err := ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	base := c.ipnet.IP
+	offset := big.NewInt(int64(n))
+	if n < 0 {
+		base = lastIP(c.ipnet)
+		offset.Add(offset, big.NewInt(1))
+	}
+	ip := addIP(base, offset)
+	if ip == nil || !c.ipnet.Contains(ip) {
+		return "", fmt.Errorf("Host %d is outside of network %s", n, cidr)
+	}
+	return ip.String(), nil
+}
+
+// CIDRHosts returns the usable host addresses of the network cidr. For IPv4
+// networks larger than /31 the network and broadcast addresses are excluded.
+func CIDRHosts(cidr string) ([]string, error) {
+	c, err := ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ones, bits := c.ipnet.Mask.Size()
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("Network %s has more than %d hosts", cidr, maxCIDRHosts)
+	}
+	first, last := c.ipnet.IP, lastIP(c.ipnet)
+	if bits == 32 && bits-ones > 1 {
+		first = addIP(first, big.NewInt(1))
+		last = addIP(last, big.NewInt(-1))
+	}
+	hosts := make([]string, 0)
+	for ip := first; bytes.Compare(ip, last) <= 0; ip = addIP(ip, big.NewInt(1)) {
+		hosts = append(hosts, ip.String())
+		if ip.Equal(last) {
+			break
+		}
+	}
+	return hosts, nil
+}
+
+// SortIPs returns ips sorted numerically, with IPv4 addresses before IPv6.
+func SortIPs(ips []string) ([]string, error) {
+	parsed := make([]net.IP, len(ips))
+	for i, s := range ips {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, errors.New("Invalid IP address " + s)
+		}
+		parsed[i] = ip
+	}
+	sorted := make([]string, len(ips))
+	copy(sorted, ips)
+	sort.Sort(byIP{sorted, parsed})
+	return sorted, nil
+}
+
+type byIP struct {
+	s   []string
+	ips []net.IP
+}
+
+func (b byIP) Len() int { return len(b.s) }
+
+func (b byIP) Less(i, j int) bool {
+	a4, b4 := b.ips[i].To4(), b.ips[j].To4()
+	if (a4 == nil) != (b4 == nil) {
+		return a4 != nil
+	}
+	return bytes.Compare(b.ips[i].To16(), b.ips[j].To16()) < 0
+}
+
+func (b byIP) Swap(i, j int) {
+	b.s[i], b.s[j] = b.s[j], b.s[i]
+	b.ips[i], b.ips[j] = b.ips[j], b.ips[i]
+}
+
+// lastIP returns the last address of the network n.
+func lastIP(n *net.IPNet) net.IP {
+	ip := make(net.IP, len(n.IP))
+	for i := range n.IP {
+		ip[i] = n.IP[i] | ^n.Mask[i]
+	}
+	return ip
+}
+
+// addIP returns ip plus offset, in the same length as ip, or nil if the result
+// does not fit.
+func addIP(ip net.IP, offset *big.Int) net.IP {
+	i := new(big.Int).SetBytes(ip)
+	i.Add(i, offset)
+	if i.Sign() < 0 {
+		return nil
+	}
+	b := i.Bytes()
+	if len(b) > len(ip) {
+		return nil
+	}
+	out := make(net.IP, len(ip))
+	copy(out[len(ip)-len(b):], b)
+	return out
+}
@@ -0,0 +1,62 @@
+package template
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCIDRHostIPv6(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "2001:db8::"},
+		{1, "2001:db8::1"},
+		{-1, "2001:db8::ffff"},
+	}
+	for _, tt := range tests {
+		got, err := CIDRHost("2001:db8::/112", tt.n)
+		if err != nil {
+			t.Errorf("CIDRHost(%d) failed: %s", tt.n, err.Error())
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("CIDRHost(%d) = %s, want %s", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestCIDRHostOutOfRange(t *testing.T) {
+	for _, n := range []int{256, -257} {
+		if _, err := CIDRHost("10.0.0.0/24", n); err == nil {
+			t.Errorf("Expected CIDRHost(%d) to fail", n)
+		}
+	}
+}
+
+func TestCIDRHostsSmallNetworks(t *testing.T) {
+	tests := map[string][]string{
+		"10.0.0.1/32": {"10.0.0.1"},
+		"10.0.0.0/31": {"10.0.0.0", "10.0.0.1"},
+		"10.0.0.0/30": {"10.0.0.1", "10.0.0.2"},
+	}
+	for cidr, want := range tests {
+		got, err := CIDRHosts(cidr)
+		if err != nil {
+			t.Errorf("CIDRHosts(%s) failed: %s", cidr, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("CIDRHosts(%s) = %v, want %v", cidr, got, want)
+		}
+	}
+	if _, err := CIDRHosts("10.0.0.0/8"); err == nil {
+		t.Errorf("Expected CIDRHosts to refuse a /8 network")
+	}
+}
+
+func TestSortIPsInvalid(t *testing.T) {
+	if _, err := SortIPs([]string{"10.0.0.1", "nope"}); err == nil {
+		t.Errorf("Expected SortIPs to fail on an invalid address")
+	}
+}
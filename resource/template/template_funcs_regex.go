@@ -0,0 +1,99 @@
+package template
+
+import (
+	"regexp"
+)
+
+// regexCache compiles regular expressions used by the regex template
+// functions once per render.
+type regexCache map[string]*regexp.Regexp
+
+// newRegexFuncMap returns the regex template functions sharing a new, empty
+// pattern cache. The input string is always the last argument, so the
+// functions can be used at the end of a pipeline.
+func newRegexFuncMap() map[string]interface{} {
+	c := make(regexCache)
+	m := make(map[string]interface{})
+	m["regexMatch"] = c.match
+	m["regexFind"] = c.find
+	m["regexFindAll"] = c.findAll
+	m["regexReplace"] = c.replace
+	m["regexSplit"] = c.split
+	return m
+}
+
+func (c regexCache) compile(pattern string) (*regexp.Regexp, error) {
+	if re, ok := c[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	c[pattern] = re
+	return re, nil
+}
+
+// match reports whether s contains a match of pattern.
+func (c regexCache) match(pattern, s string) (bool, error) {
+	re, err := c.compile(pattern)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(s), nil
+}
+
+// find returns the first match of pattern in s, or the text of its first
+// capture group if pattern has one. It returns "" if there is no match.
+func (c regexCache) find(pattern, s string) (string, error) {
+	re, err := c.compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	m := re.FindStringSubmatch(s)
+	if m == nil {
+		return "", nil
+	}
+	return m[submatchIndex(re)], nil
+}
+
+// findAll returns every match of pattern in s, or of its first capture group
+// if pattern has one.
+func (c regexCache) findAll(pattern, s string) ([]string, error) {
+	re, err := c.compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	i := submatchIndex(re)
+	vs := make([]string, 0)
+	for _, m := range re.FindAllStringSubmatch(s, -1) {
+		vs = append(vs, m[i])
+	}
+	return vs, nil
+}
+
+// replace replaces all matches of pattern in s with repl. Inside repl, $1 or
+// ${name} refer to capture groups as in regexp.Regexp.Expand.
+func (c regexCache) replace(pattern, repl, s string) (string, error) {
+	re, err := c.compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(s, repl), nil
+}
+
+// split slices s into the substrings separated by matches of pattern.
+func (c regexCache) split(pattern, s string) ([]string, error) {
+	re, err := c.compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return re.Split(s, -1), nil
+}
+
+func submatchIndex(re *regexp.Regexp) int {
+	if re.NumSubexp() > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,113 @@
+package template
+
+import (
+	"fmt"
+	"time"
+)
+
+// timeLayouts maps layout names accepted by the time functions to the
+// layouts of the time package.
+var timeLayouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"UnixDate":    time.UnixDate,
+	"RubyDate":    time.RubyDate,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC850":      time.RFC850,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Kitchen":     time.Kitchen,
+}
+
+// timeLayout returns the layout named name, or name itself if it is not a
+// known name and so is a layout already.
+func timeLayout(name string) string {
+	if layout, ok := timeLayouts[name]; ok {
+		return layout
+	}
+	return name
+}
+
+// FixedTimeFuncMap returns the time functions with the current time pinned to
+// fixed, a time in RFC3339 format, so that renders are reproducible.
+func FixedTimeFuncMap(fixed string) (map[string]interface{}, error) {
+	t, err := time.Parse(time.RFC3339, fixed)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid fixed time %q - %s", fixed, err.Error())
+	}
+	return newTimeFuncMap(func() time.Time { return t }), nil
+}
+
+// newTimeFuncMap returns the time functions using now as the current time.
+// Times are always the last argument, so the functions can be chained in a
+// pipeline.
+func newTimeFuncMap(now func() time.Time) map[string]interface{} {
+	m := make(map[string]interface{})
+	m["datetime"] = now
+	m["now"] = func(layout ...string) string {
+		if len(layout) > 0 {
+			return now().Format(timeLayout(layout[0]))
+		}
+		return now().Format(time.RFC3339)
+	}
+	m["parseTime"] = ParseTime
+	m["unixTime"] = UnixTime
+	m["dateAdd"] = DateAdd
+	m["durationBetween"] = DurationBetween
+	m["toTimezone"] = ToTimezone
+	return m
+}
+
+// ParseTime parses value with layout, which may be a Go time layout or the
+// name of one of the time package layouts, e.g. "RFC3339".
+func ParseTime(layout, value string) (time.Time, error) {
+	return time.Parse(timeLayout(layout), value)
+}
+
+// UnixTime returns the local time corresponding to the given Unix time in
+// seconds.
+func UnixTime(seconds interface{}) (time.Time, error) {
+	sec, err := toInt(seconds)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// DateAdd returns t plus duration, given as a time.Duration or a string like
+// "72h" or "-30m".
+func DateAdd(duration interface{}, t time.Time) (time.Time, error) {
+	d, err := toDuration(duration)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.Add(d), nil
+}
+
+// DurationBetween returns the duration from start to end, which is negative
+// if end is before start.
+func DurationBetween(start, end time.Time) time.Duration {
+	return end.Sub(start)
+}
+
+// ToTimezone returns t in the named IANA time zone, e.g. "Europe/Paris" or
+// "UTC".
+func ToTimezone(name string, t time.Time) (time.Time, error) {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.In(loc), nil
+}
+
+func toDuration(data interface{}) (time.Duration, error) {
+	switch v := data.(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		return time.ParseDuration(v)
+	}
+	return 0, fmt.Errorf("Invalid duration %v", data)
+}
@@ -4,24 +4,37 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/wuranbo/confd/backends"
 )
 
 const (
-	tomlFilePath = "test/confd/config.toml"
-	tmplFilePath = "test/templates/test.conf.tmpl"
+	tomlFilePath   = "test/confd/config.toml"
+	tmplFilePath   = "test/templates/test.conf.tmpl"
+	partialDirPath = "test/templates/partials"
 )
 
 type templateTest struct {
 	desc        string                  // description of the test (for helpful errors)
 	toml        string                  // toml file contents
 	tmpl        string                  // template file contents
+	partials    map[string]string       // partial file names and contents
 	expected    string                  // expected generated file contents
 	updateStore func(*TemplateResource) // function for setting values in store
 }
 
+// testHostFacts are the host facts every test template is rendered with.
+var testHostFacts = &HostFacts{
+	Hostname:   "web1",
+	FQDN:       "web1.example.com",
+	PrimaryIP:  "10.0.0.5",
+	Interfaces: map[string][]string{"eth0": []string{"10.0.0.5", "fe80::1"}, "lo": []string{"127.0.0.1"}},
+	CPUCount:   4,
+	MemTotal:   8589934592,
+}
+
 // templateTests is an array of templateTest structs, each representing a test of
 // some aspect of template processing. When the input tmpl and toml files are
 // processed, they should produce a config file matching expected.
@@ -323,10 +336,491 @@ dir: /test/data
 			tr.store.Set("/test/data/def", "child")
 		},
 	},
+
+	templateTest{
+		desc: "getv default test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/url",
+]
+`,
+		tmpl: `
+url = {{getv "/test/url" "http://localhost"}}
+user = {{getv "/test/user" "nobody"}}
+`,
+		expected: `
+url = http://www.abc.com
+user = nobody
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/url", "http://www.abc.com")
+		},
+	},
+
+	templateTest{
+		desc: "typed getters test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test",
+]
+`,
+		tmpl: `
+port = {{getint "/test/port"}}
+workers = {{getint "/test/workers" 4}}
+debug = {{if getbool "/test/debug"}}on{{else}}off{{end}}
+gzip = {{getbool "/test/gzip" true}}
+timeout = {{(getduration "/test/timeout").Seconds}}
+keepalive = {{getduration "/test/keepalive" "1m"}}
+`,
+		expected: `
+port = 8080
+workers = 4
+debug = on
+gzip = true
+timeout = 90
+keepalive = 1m0s
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/port", "8080")
+			tr.store.Set("/test/debug", "true")
+			tr.store.Set("/test/timeout", "1m30s")
+		},
+	},
+
+	templateTest{
+		desc: "partials test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/server",
+]
+`,
+		tmpl: `
+{{template "header.tmpl"}}
+{{range gets "/test/server/*"}}
+{{include "nginx/server.tmpl" .}}
+{{end}}
+`,
+		partials: map[string]string{
+			"header.tmpl":       `# generated by confd{{template "notice.tmpl"}}`,
+			"notice.tmpl":       `, do not edit`,
+			"nginx/server.tmpl": `server {{.Value}}; # {{base .Key}}`,
+			// Partials no template uses are not parsed, so they may hold
+			// text that only parses with other delimiters.
+			"haproxy/header.tmpl": `http-request set-header X-Tmpl {{literal}}`,
+		},
+		expected: `
+# generated by confd, do not edit
+
+server 10.0.0.1:80; # app1
+
+server 10.0.0.2:80; # app2
+
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/server/app1", "10.0.0.1:80")
+			tr.store.Set("/test/server/app2", "10.0.0.2:80")
+		},
+	},
+
+	templateTest{
+		desc: "custom delimiters test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+left_delimiter = "[["
+right_delimiter = "]]"
+keys = [
+    "/test/backend",
+]
+`,
+		tmpl: `
+backend app
+    server app1 [[getv "/test/backend"]] check
+    [[template "haproxy/header.tmpl"]]
+`,
+		partials: map[string]string{
+			"haproxy/header.tmpl": `http-request set-header X-Tmpl {{literal}}`,
+			"check.sh":            `[[ -f /etc/app.conf ]] && echo ok`,
+		},
+		expected: `
+backend app
+    server app1 10.0.0.1:80 check
+    http-request set-header X-Tmpl {{literal}}
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/backend", "10.0.0.1:80")
+		},
+	},
+
+	templateTest{
+		desc: "regex test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test",
+]
+`,
+		tmpl: `
+{{$addr := getv "/test/addr"}}
+{{if regexMatch "^[a-z.]+:[0-9]+$" $addr}}valid{{end}}
+port: {{regexFind ":([0-9]+)$" $addr}}
+host: {{$addr | regexFind "^[^:]+"}}
+versions: {{join (regexFindAll "v([0-9]+)" (getv "/test/versions")) ","}}
+version: {{getv "/test/image" | regexReplace "^.*:v?" ""}}
+{{range regexSplit "[,; ]+" (getv "/test/hosts")}}
+host: {{.}}
+{{end}}
+`,
+		expected: `
+
+valid
+port: 8080
+host: db.example.com
+versions: 1,2,10
+version: 1.4.2
+
+host: a
+
+host: b
+
+host: c
+
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/addr", "db.example.com:8080")
+			tr.store.Set("/test/versions", "api-v1 api-v2 api-v10")
+			tr.store.Set("/test/image", "nginx:v1.4.2")
+			tr.store.Set("/test/hosts", "a, b;c")
+		},
+	},
+
+	templateTest{
+		desc: "encoding and escaping test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test",
+]
+`,
+		tmpl: `
+{{$v := getv "/test/value"}}
+base64: {{base64Encode $v}}
+decoded: {{base64Decode (getv "/test/encoded")}}
+sha256: {{sha256 "abc"}}
+url: http://example.com/?q={{urlEncode $v}}
+shell: echo {{shellQuote $v}}
+json: {"value": "{{jsonEscape $v}}"}
+xml: <value>{{xmlEscape $v}}</value>
+ini: value = {{iniEscape $v}}
+plain: value = {{iniEscape "plain value"}}
+`,
+		expected: `
+
+base64: aXQncyAiYSIgPGI+ICYgYw==
+decoded: hello
+sha256: ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad
+url: http://example.com/?q=it%27s+%22a%22+%3Cb%3E+%26+c
+shell: echo 'it'\''s "a" <b> & c'
+json: {"value": "it's \"a\" <b> & c"}
+xml: <value>it&#39;s &#34;a&#34; &lt;b&gt; &amp; c</value>
+ini: value = "it's \"a\" <b> & c"
+plain: value = plain value
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/value", `it's "a" <b> & c`)
+			tr.store.Set("/test/encoded", "aGVsbG8=")
+		},
+	},
+
+	templateTest{
+		desc: "host facts test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test",
+]
+`,
+		tmpl: `
+hostname: {{hostname}}
+fqdn: {{fqdn}}
+listen: {{primaryIP}}
+eth0: {{join (interfaceAddrs "eth0") " "}}
+workers: {{cpuCount}}
+memory: {{strdiv memTotal 1048576}}m
+`,
+		expected: `
+hostname: web1
+fqdn: web1.example.com
+listen: 10.0.0.5
+eth0: 10.0.0.5 fe80::1
+workers: 4
+memory: 8192m
+`,
+		updateStore: func(tr *TemplateResource) {},
+	},
+
+	templateTest{
+		desc: "network test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test",
+]
+`,
+		tmpl: `
+{{$net := getv "/test/subnet"}}
+{{with parseCIDR $net}}ip: {{.IP}} prefix: {{.PrefixLen}}{{end}}
+network: {{cidrNetwork $net}}
+netmask: {{cidrNetmask $net}}
+broadcast: {{cidrBroadcast $net}}
+gateway: {{cidrHost $net 1}}
+last: {{cidrHost $net -2}}
+hosts: {{join (cidrHosts "192.168.1.0/29") " "}}
+{{range sortIPs (getvs "/test/peers/*")}}
+{{if cidrContains $net .}}allow{{else}}deny{{end}} {{.}};
+{{end}}
+`,
+		expected: `
+
+ip: 10.1.2.3 prefix: 22
+network: 10.1.0.0
+netmask: 255.255.252.0
+broadcast: 10.1.3.255
+gateway: 10.1.0.1
+last: 10.1.3.254
+hosts: 192.168.1.1 192.168.1.2 192.168.1.3 192.168.1.4 192.168.1.5 192.168.1.6
+
+allow 10.1.0.9;
+
+allow 10.1.2.10;
+
+deny 10.2.0.1;
+
+deny ::1;
+
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/subnet", "10.1.2.3/22")
+			tr.store.Set("/test/peers/a", "10.2.0.1")
+			tr.store.Set("/test/peers/b", "10.1.2.10")
+			tr.store.Set("/test/peers/c", "::1")
+			tr.store.Set("/test/peers/d", "10.1.0.9")
+		},
+	},
+
+	templateTest{
+		desc: "collections test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test",
+]
+`,
+		tmpl: `
+{{$ports := dict "http" 80 "https" 443}}
+{{range $name, $port := $ports}}{{$name}}={{$port}} {{end}}
+keys: {{join (keys $ports) ","}}
+values: {{values $ports}}
+list: {{list 1 "a" true}}
+hosts: {{join (append (getvs "/test/hosts/*") "c" "a") ","}}
+uniq: {{join (uniq (append (getvs "/test/hosts/*") "c" "a")) ","}}
+{{$ups := gets "/test/upstream/*"}}
+{{range sortBy "value,numeric,reverse" $ups}}server {{base .Key}} weight={{.Value}};
+{{end}}
+{{range filter "key" "/test/upstream/web*" $ups}}web: {{.Key}}
+{{end}}
+{{range filter "value,regex" "^[0-9]$" $ups}}light: {{.Key}}
+{{end}}
+first: {{(first $ups).Key}}
+last: {{(last $ups).Key}}
+sublist: {{join (sublist (keys $ports) 1) ","}} {{len (sublist $ups 0 2)}}
+slice: {{slice "abcdef" 1 3}} {{slice (list 1 2 3) 0 1 2}}
+`,
+		expected: `
+
+http=80 https=443 
+keys: http,https
+values: [80 443]
+list: [1 a true]
+hosts: a,b,c,a
+uniq: a,b,c
+
+server web10 weight=20;
+server api weight=10;
+server web2 weight=5;
+
+web: /test/upstream/web10
+web: /test/upstream/web2
+
+light: /test/upstream/web2
+
+first: /test/upstream/api
+last: /test/upstream/web2
+sublist: https 2
+slice: bc [1]
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/hosts/1", "a")
+			tr.store.Set("/test/hosts/2", "b")
+			tr.store.Set("/test/upstream/api", "10")
+			tr.store.Set("/test/upstream/web2", "5")
+			tr.store.Set("/test/upstream/web10", "20")
+		},
+	},
+
+	templateTest{
+		desc: "tree test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/services",
+]
+`,
+		tmpl: `
+{{range $name, $svc := tree "/services"}}
+upstream {{$name}} {
+{{range $id, $inst := $svc}}    server {{$inst.host}}:{{$inst.port}}; # {{$id}}
+{{end}}}
+{{end}}
+{{with tree "/config"}}{{index . ""}} {{.tls.cert}}{{end}}
+{{len (tree "/nada")}}
+`,
+		expected: `
+
+upstream api {
+    server 10.0.0.3:9000; # a
+}
+
+upstream web {
+    server 10.0.0.1:80; # 1
+    server 10.0.0.2:80; # 2
+}
+
+enabled /etc/cert.pem
+0
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/services/web/1/host", "10.0.0.1")
+			tr.store.Set("/services/web/1/port", "80")
+			tr.store.Set("/services/web/2/host", "10.0.0.2")
+			tr.store.Set("/services/web/2/port", "80")
+			tr.store.Set("/services/api/a/host", "10.0.0.3")
+			tr.store.Set("/services/api/a/port", "9000")
+			tr.store.Set("/services2/other/x/host", "10.0.0.9")
+			tr.store.Set("/config", "enabled")
+			tr.store.Set("/config/tls/cert", "/etc/cert.pem")
+		},
+	},
+
+	templateTest{
+		desc: "time test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+fixed_time = "2015-01-23T13:34:56Z"
+keys = [
+    "/test",
+]
+
+[template.vars]
+rotated = 1420156800
+`,
+		tmpl: `
+# Generated by confd {{now}}
+date: {{now "2006-01-02"}} {{now "Kitchen"}}
+datetime: {{datetime.Year}}
+{{$expires := parseTime "RFC3339" (getv "/test/expires")}}
+expires: {{$expires | toTimezone "America/New_York"}}
+renew: {{dateAdd "-720h" $expires | toTimezone "UTC"}}
+{{$left := durationBetween datetime $expires}}
+left: {{$left}} {{if lt $left.Hours 2400.0}}renew soon{{end}}
+created: {{(unixTime (getv "/test/created")).UTC.Format "2006-01-02 15:04"}}
+rotated: {{(unixTime .rotated).UTC.Format "2006-01-02 15:04"}}
+`,
+		expected: `
+# Generated by confd 2015-01-23T13:34:56Z
+date: 2015-01-23 1:34PM
+datetime: 2015
+
+expires: 2015-03-01 07:00:00 -0500 EST
+renew: 2015-01-30 12:00:00 +0000 UTC
+
+left: 886h25m4s renew soon
+created: 2015-01-01 00:00
+rotated: 2015-01-02 00:00
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/expires", "2015-03-01T12:00:00Z")
+			tr.store.Set("/test/created", "1420070400")
+		},
+	},
+
+	templateTest{
+		desc: "vars test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test",
+]
+
+[template.vars]
+name = "web"
+port = 8080
+server_name = "{{hostname}}.{{getenv \"CONFD_TEST_DOMAIN\"}}"
+aliases = ["www.{{getenv \"CONFD_TEST_DOMAIN\"}}", "static"]
+
+[template.vars.tls]
+enabled = true
+`,
+		tmpl: `
+upstream {{.name}} {
+    server {{getv "/test/host"}}:{{.port}};
+}
+server_name {{.server_name}}{{range .aliases}} {{.}}{{end}};
+{{if .tls.enabled}}listen 443 ssl;{{end}}
+`,
+		expected: `
+upstream web {
+    server 10.0.0.1:8080;
+}
+server_name web1.example.com www.example.com static;
+listen 443 ssl;
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/host", "10.0.0.1")
+		},
+	},
 }
 
 // TestTemplates runs all tests in templateTests
 func TestTemplates(t *testing.T) {
+	os.Setenv("CONFD_TEST_DOMAIN", "example.com")
+	defer os.Unsetenv("CONFD_TEST_DOMAIN")
 	for _, tt := range templateTests {
 		ExecuteTestTemplate(tt, t)
 	}
@@ -359,6 +853,96 @@ func ExecuteTestTemplate(tt templateTest, t *testing.T) {
 	}
 }
 
+// strictTemplateTests are processed like templateTests, but rendering each of
+// them is expected to fail because the resource is in strict mode.
+var strictTemplateTests = []templateTest{
+
+	templateTest{
+		desc: "strict missing map key test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+strict = true
+keys = [
+    "/test/data",
+]
+`,
+		tmpl: `
+{{$data := json (getv "/test/data")}}
+port: {{$data.port}}
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/data", `{"host": "localhost"}`)
+		},
+	},
+
+	templateTest{
+		desc: "strict ls test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+strict = true
+keys = [
+    "/test/data",
+]
+`,
+		tmpl: `
+{{range ls "/test/nada"}}
+value: {{.}}
+{{end}}
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/data/abc", "123")
+		},
+	},
+
+	templateTest{
+		desc: "invalid getint test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/port",
+]
+`,
+		tmpl: `
+port = {{getint "/test/port"}}
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/port", "http")
+		},
+	},
+}
+
+// TestStrictTemplates runs all tests in strictTemplateTests
+func TestStrictTemplates(t *testing.T) {
+	for _, tt := range strictTemplateTests {
+		ExecuteFailingTestTemplate(tt, t)
+	}
+}
+
+// ExecuteFailingTestTemplate builds a TemplateResource based on the toml and
+// tmpl files described in the templateTest and checks that staging the config
+// file fails.
+func ExecuteFailingTestTemplate(tt templateTest, t *testing.T) {
+	setupDirectoriesAndFiles(tt, t)
+	defer os.RemoveAll("test")
+
+	tr, err := templateResource()
+	if err != nil {
+		t.Fatalf("%s: failed to create TemplateResource: %s", tt.desc, err.Error())
+	}
+
+	tt.updateStore(tr)
+
+	if err := tr.createStageFile(); err == nil {
+		t.Errorf("%s: expected createStageFile to fail", tt.desc)
+	}
+}
+
 // setUpDirectoriesAndFiles creates folders for the toml, tmpl, and output files and
 // creates the toml and tmpl files as specified in the templateTest struct.
 func setupDirectoriesAndFiles(tt templateTest, t *testing.T) {
@@ -376,6 +960,16 @@ func setupDirectoriesAndFiles(tt templateTest, t *testing.T) {
 	if err := ioutil.WriteFile(tmplFilePath, []byte(tt.tmpl), os.ModePerm); err != nil {
 		t.Errorf(tt.desc + ": failed to write toml file: " + err.Error())
 	}
+	// create the shared partials
+	for name, text := range tt.partials {
+		partialPath := filepath.Join(partialDirPath, name)
+		if err := os.MkdirAll(filepath.Dir(partialPath), os.ModePerm); err != nil {
+			t.Errorf(tt.desc + ": failed to create partial directory: " + err.Error())
+		}
+		if err := ioutil.WriteFile(partialPath, []byte(text), os.ModePerm); err != nil {
+			t.Errorf(tt.desc + ": failed to write partial file: " + err.Error())
+		}
+	}
 	// create tmp directory for output
 	if err := os.MkdirAll("./test/tmp", os.ModePerm); err != nil {
 		t.Errorf(tt.desc + ": failed to create tmp directory: " + err.Error())
@@ -392,8 +986,10 @@ func templateResource() (*TemplateResource, error) {
 	}
 
 	config := Config{
+		HostFacts:   testHostFacts,
 		StoreClient: client, // not used but must be set
 		TemplateDir: "./test/templates",
+		PartialDir:  partialDirPath,
 	}
 
 	tr, err := NewTemplateResource(tomlFilePath, config)
@@ -404,3 +1000,18 @@ func templateResource() (*TemplateResource, error) {
 	tr.FileMode = 0666
 	return tr, nil
 }
+
+func TestUnixTimeInputs(t *testing.T) {
+	for _, seconds := range []interface{}{int64(1420070400), 1420070400, "1420070400", float64(1420070400)} {
+		tm, err := UnixTime(seconds)
+		if err != nil {
+			t.Fatalf("UnixTime(%#v) failed: %s", seconds, err.Error())
+		}
+		if tm.Unix() != 1420070400 {
+			t.Errorf("UnixTime(%#v) = %d, want 1420070400", seconds, tm.Unix())
+		}
+	}
+	if _, err := UnixTime(true); err == nil {
+		t.Error("Expected UnixTime of a bool to fail")
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"syscall"
 
 	"github.com/wuranbo/confd/log"
@@ -92,6 +94,98 @@ func sameConfig(src, dest string) (bool, error) {
 	return true, nil
 }
 
+// maxSymlinks is the number of symbolic links resolveSymlinks follows before
+// giving up.
+const maxSymlinks = 255
+
+// replaceFile moves src over dest, syncing src to disk before the rename and
+// the directory of dest after it, so the new config survives a crash. When
+// dest is a mount point, such as a bind-mounted file, it cannot be renamed
+// over. If overwrite is set its contents are then overwritten in place with
+// those of src instead, with the given mode, uid and gid.
+// It returns an error if any.
+func replaceFile(src, dest string, mode os.FileMode, uid, gid int, overwrite bool) error {
+	if err := syncPath(src); err != nil {
+		return err
+	}
+	err := os.Rename(src, dest)
+	if err == nil {
+		return syncPath(filepath.Dir(dest))
+	}
+	if !strings.Contains(err.Error(), "device or resource busy") {
+		return err
+	}
+	if !overwrite {
+		return errors.New("Cannot replace " + dest + ", it is likely a mount point - " + err.Error())
+	}
+	log.Debug("Rename failed - target is likely a mount. Overwriting it in place instead")
+	return overwriteFile(src, dest, mode, uid, gid)
+}
+
+// overwriteFile writes the contents of src over those of dest in place, and
+// syncs dest to disk. dest is truncated only after the new contents are
+// written, so it is never seen empty, though readers may see a mix of the
+// previous and new contents while it is written.
+// It returns an error if any.
+func overwriteFile(src, dest string, mode os.FileMode, uid, gid int) error {
+	contents, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(contents); err != nil {
+		return err
+	}
+	if err := f.Truncate(int64(len(contents))); err != nil {
+		return err
+	}
+	// make sure the mode, owner and group match the source file
+	f.Chmod(mode)
+	f.Chown(uid, gid)
+	return f.Sync()
+}
+
+// syncPath flushes the file or directory at path to disk.
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// resolveSymlinks returns the file path links to, following each symbolic
+// link in turn, or path itself if it is not a link. The file a link points to
+// does not need to exist.
+func resolveSymlinks(path string) (string, error) {
+	for i := 0; i < maxSymlinks; i++ {
+		fi, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return path, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return path, nil
+		}
+		link, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(path), link)
+		}
+		path = link
+	}
+	return "", errors.New("Too many levels of symbolic links at " + path)
+}
+
 func RecursiveFindFiles(root string, pattern string) ([]string, error) {
 	return recursiveFindFiles(root, pattern)
 }
@@ -124,3 +124,77 @@ func TestRecursiveFindFiles(t *testing.T) {
 		}
 	}
 }
+
+func TestResolveSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "releases", "app.conf")
+	if err := os.Mkdir(filepath.Dir(target), 0755); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := os.Symlink("releases/app.conf", filepath.Join(dir, "current.conf")); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := os.Symlink(filepath.Join(dir, "current.conf"), filepath.Join(dir, "app.conf")); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := os.Symlink("loop", filepath.Join(dir, "loop")); err != nil {
+		t.Fatal(err.Error())
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{filepath.Join(dir, "app.conf"), target},
+		{filepath.Join(dir, "current.conf"), target},
+		{filepath.Join(dir, "missing.conf"), filepath.Join(dir, "missing.conf")},
+	}
+	for _, tt := range tests {
+		got, err := resolveSymlinks(tt.path)
+		if err != nil {
+			t.Fatalf("resolveSymlinks(%s) failed: %s", tt.path, err.Error())
+		}
+		if got != tt.want {
+			t.Errorf("resolveSymlinks(%s) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+	if _, err := resolveSymlinks(filepath.Join(dir, "loop")); err == nil {
+		t.Error("Expected resolveSymlinks of a link loop to fail")
+	}
+}
+
+func TestOverwriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dest := filepath.Join(dir, "dest")
+	if err := ioutil.WriteFile(src, []byte("new"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := ioutil.WriteFile(dest, []byte("previous contents"), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := overwriteFile(src, dest, 0640, os.Getuid(), os.Getgid()); err != nil {
+		t.Fatal(err.Error())
+	}
+	contents, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(contents) != "new" {
+		t.Errorf("Expected %q, got %q", "new", string(contents))
+	}
+	fi, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if fi.Mode() != 0640 {
+		t.Errorf("Expected mode 0640, got %s", fi.Mode())
+	}
+}
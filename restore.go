@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/wuranbo/confd/resource/template"
+)
+
+// restore runs the restore command, which puts a backup of a template
+// resource back in place, or lists its backups when no version is given.
+// It returns an error if any.
+func restore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	resource := flags.String("resource", "", "name of the template resource, its file name without .toml")
+	version := flags.Int("version", 0, "backup to restore, 1 being the most recent (list backups if not set)")
+	flags.Parse(args)
+	if *resource == "" {
+		return errors.New("restore requires -resource")
+	}
+	if *version == 0 {
+		backups, err := template.ListBackups(templateConfig, *resource)
+		if err != nil {
+			return err
+		}
+		for i, b := range backups {
+			fmt.Fprintf(os.Stdout, "%d\t%s\t%s\t%s\n", i+1, b.Time.Local().Format("2006-01-02 15:04:05"), b.Hash, b.Path)
+		}
+		return nil
+	}
+	return template.Restore(templateConfig, *resource, *version)
+}